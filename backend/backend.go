@@ -0,0 +1,158 @@
+// Package backend serves the endpoints a LibreSpeed client tests against, so
+// the same binary can be both ends of a test: on a LAN, between two sites, or
+// in integration tests, with no web server or PHP installed.
+package backend
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+// Paths of the endpoints, relative to the server's base URL. They are what
+// Entry puts in the server list, so a client finds them.
+const (
+	DownloadPath = "garbage"
+	UploadPath   = "empty"
+	PingPath     = "empty"
+	GetIPPath    = "getIP"
+)
+
+const (
+	// chunkSize, and the default and largest ckSize, match the reference
+	// backends, so a client asks for the same amount from either
+	chunkSize     = 1 << 20
+	defaultChunks = 4
+	maxChunks     = 1024
+)
+
+// NewHandler returns a handler serving the download, upload, ping and getIP
+// endpoints at the root of whatever it is mounted on.
+func NewHandler() http.Handler {
+	// one random chunk, sent over and over: random so nothing on the path
+	// can compress it, and generated once so the server is never the
+	// bottleneck
+	chunk := make([]byte, chunkSize)
+	rand.Read(chunk)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/"+DownloadPath, func(w http.ResponseWriter, r *http.Request) {
+		garbage(w, r, chunk)
+	})
+	mux.HandleFunc("/"+UploadPath, empty)
+	mux.HandleFunc("/"+GetIPPath, getIP)
+	return mux
+}
+
+// Entry returns the server list entry for a backend served at baseURL, as
+// --local-json and --server-json expect it
+func Entry(id int, name, baseURL string) defs.Server {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return defs.Server{
+		ID:          id,
+		Name:        name,
+		Server:      baseURL,
+		DownloadURL: DownloadPath,
+		UploadURL:   UploadPath,
+		PingURL:     PingPath,
+		GetIPURL:    GetIPPath,
+	}
+}
+
+// noCache keeps anything between client and server from answering in the
+// server's place, and lets browser-based clients on other origins in
+func noCache(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
+	h.Set("Pragma", "no-cache")
+	h.Set("Access-Control-Allow-Origin", "*")
+	h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	h.Set("Access-Control-Allow-Headers", "Content-Encoding, Content-Type")
+}
+
+// garbage sends ckSize chunks of incompressible data
+func garbage(w http.ResponseWriter, r *http.Request, chunk []byte) {
+	noCache(w)
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	chunks := defaultChunks
+	if n, err := strconv.Atoi(r.URL.Query().Get("ckSize")); err == nil && n > 0 {
+		chunks = min(n, maxChunks)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Description", "File Transfer")
+	h.Set("Content-Disposition", "attachment; filename=random.dat")
+	h.Set("Content-Transfer-Encoding", "binary")
+	h.Set("Content-Length", strconv.Itoa(chunks*len(chunk)))
+
+	for range chunks {
+		if _, err := w.Write(chunk); err != nil {
+			return
+		}
+	}
+}
+
+// empty reads and discards the request body: the upload and ping endpoint.
+// A ping must get an empty 200, which is how the client tells the server is
+// up.
+func empty(w http.ResponseWriter, r *http.Request) {
+	noCache(w)
+	io.Copy(io.Discard, r.Body)
+}
+
+// getIP tells the client the address it connects from. There is no ISP
+// lookup, so rawIspInfo is empty like it is on the reference backends
+// without one.
+func getIP(w http.ResponseWriter, r *http.Request) {
+	noCache(w)
+
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+
+	processed := ip
+	if kind := addressKind(net.ParseIP(ip)); kind != "" {
+		processed += " - " + kind
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(defs.GetIPResult{
+		ProcessedString: processed,
+		RawISPInfo:      json.RawMessage(`""`),
+	})
+}
+
+// addressKind describes addresses there is no ISP for, the way the reference
+// backend does
+func addressKind(ip net.IP) string {
+	family := "IPv6"
+	if ip.To4() != nil {
+		family = "IPv4"
+	}
+
+	switch {
+	case ip == nil:
+		return ""
+	case ip.IsLoopback():
+		return "localhost " + family + " access"
+	case ip.IsPrivate():
+		return "private " + family + " access"
+	case ip.IsLinkLocalUnicast():
+		return "link-local " + family + " access"
+	default:
+		return ""
+	}
+}
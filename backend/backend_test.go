@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+func TestGarbageHonoursChunkSize(t *testing.T) {
+	ts := httptest.NewServer(NewHandler())
+	defer ts.Close()
+
+	cases := []struct {
+		query string
+		want  int
+	}{
+		{"?ckSize=2", 2 * chunkSize},
+		{"", defaultChunks * chunkSize},
+		{"?ckSize=junk", defaultChunks * chunkSize},
+		{"?ckSize=5000", maxChunks * chunkSize},
+	}
+
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + "/" + DownloadPath + c.query)
+			if err != nil {
+				t.Fatalf("GET: %v", err)
+			}
+			defer resp.Body.Close()
+
+			n, err := io.Copy(io.Discard, resp.Body)
+			if err != nil {
+				t.Fatalf("reading body: %v", err)
+			}
+			if int(n) != c.want {
+				t.Errorf("got %d byte(s), want %d", n, c.want)
+			}
+		})
+	}
+}
+
+func TestEmptyAnswersWithNothing(t *testing.T) {
+	ts := httptest.NewServer(NewHandler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/"+UploadPath, "application/octet-stream", strings.NewReader(strings.Repeat("x", 1<<16)))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || len(b) != 0 {
+		t.Errorf("got %d with %d byte(s), want an empty 200", resp.StatusCode, len(b))
+	}
+}
+
+func TestGetIPReportsTheClient(t *testing.T) {
+	ts := httptest.NewServer(NewHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/" + GetIPPath)
+	if err != nil {
+		t.Fatalf("GET: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res defs.GetIPResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if ip := res.IP(); net.ParseIP(ip) == nil || !net.ParseIP(ip).IsLoopback() {
+		t.Errorf("IP() = %q from %q, want the loopback address", ip, res.ProcessedString)
+	}
+}
+
+// A server described by Entry has to pass the checks a run makes before
+// testing it.
+func TestEntryIsUp(t *testing.T) {
+	ts := httptest.NewServer(NewHandler())
+	defer ts.Close()
+
+	s := Entry(1, "test", ts.URL)
+	if !s.IsUp() {
+		t.Error("IsUp = false for a running backend")
+	}
+}
@@ -0,0 +1,269 @@
+// Package backendtest starts LibreSpeed backends with known network
+// conditions, so what a client measures can be checked against what the link
+// was made to do: a server shaped to 50 Mbps should be reported at 50 Mbps,
+// one with 20 ms of added latency at 20 ms more ping.
+//
+// Conditions are simulated on loopback, on top of whatever the host adds, so
+// assertions should leave room for scheduling noise.
+package backendtest
+
+import (
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/backend"
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+// Config describes the link a Server simulates. The zero value is an
+// unshaped, well-behaved backend.
+type Config struct {
+	// Mbps limits every connection, in each direction, to this many
+	// megabits per second. Zero is unlimited.
+	Mbps float64
+	// Latency is added before every response, and Jitter is the most a
+	// uniformly random extra delay adds on top of it
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// ErrorRate is the share of download and upload requests answered with
+	// ErrorStatus (503 when zero) instead of being served
+	ErrorRate   float64
+	ErrorStatus int
+	// ResetRate is the share of connections reset once they have moved
+	// ResetAfter bytes (256 KiB when zero), sent and received together, so
+	// both downloads and uploads are cut short
+	ResetRate  float64
+	ResetAfter int64
+
+	// Seed makes the random choices repeatable; zero picks one at random
+	Seed uint64
+}
+
+// Server is a running backend. It is closed when the test that started it
+// ends.
+type Server struct {
+	*httptest.Server
+
+	cfg Config
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	errors atomic.Int64
+	resets atomic.Int64
+}
+
+// New starts a backend simulating cfg, and closes it when t ends
+func New(t testing.TB, cfg Config) *Server {
+	t.Helper()
+
+	if cfg.ErrorStatus == 0 {
+		cfg.ErrorStatus = http.StatusServiceUnavailable
+	}
+	if cfg.ResetAfter == 0 {
+		cfg.ResetAfter = 256 << 10
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+
+	s := &Server{cfg: cfg, rng: rand.New(rand.NewPCG(seed, seed))}
+	s.Server = httptest.NewUnstartedServer(s.wrap(backend.NewHandler()))
+	s.Listener = &listener{Listener: s.Listener, s: s}
+	s.Start()
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Entry returns the server list entry for the backend
+func (s *Server) Entry(id int) defs.Server {
+	return backend.Entry(id, "backendtest", s.URL)
+}
+
+// InjectedErrors returns how many requests were answered with ErrorStatus
+func (s *Server) InjectedErrors() int {
+	return int(s.errors.Load())
+}
+
+// InjectedResets returns how many connections were reset
+func (s *Server) InjectedResets() int {
+	return int(s.resets.Load())
+}
+
+// chance returns true with probability p
+func (s *Server) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rng.Float64() < p
+}
+
+// delay returns the latency to add to one response
+func (s *Server) delay() time.Duration {
+	d := s.cfg.Latency
+	if s.cfg.Jitter > 0 {
+		s.mu.Lock()
+		d += time.Duration(s.rng.Int64N(int64(s.cfg.Jitter) + 1))
+		s.mu.Unlock()
+	}
+	return d
+}
+
+// wrap adds latency to every request, and failures to the transfers
+func (s *Server) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := s.delay(); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		// pings are GETs to the upload path, and are not transfers
+		transfer := r.URL.Path == "/"+backend.DownloadPath ||
+			(r.URL.Path == "/"+backend.UploadPath && r.Method == http.MethodPost)
+		if transfer && s.chance(s.cfg.ErrorRate) {
+			s.errors.Add(1)
+			http.Error(w, http.StatusText(s.cfg.ErrorStatus), s.cfg.ErrorStatus)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// listener hands out connections shaped and broken the way the config says
+type listener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	sc := &conn{Conn: c, s: l.s}
+	if l.s.cfg.Mbps > 0 {
+		bytesPerSecond := l.s.cfg.Mbps * 125000
+		sc.read = &pacer{bytesPerSecond: bytesPerSecond}
+		sc.write = &pacer{bytesPerSecond: bytesPerSecond}
+	}
+	if l.s.chance(l.s.cfg.ResetRate) {
+		sc.resetAfter = l.s.cfg.ResetAfter
+	}
+	return sc, nil
+}
+
+// shapeChunk is the most a connection moves at once, so pacing is smooth at
+// the scale of the client's rate samples
+const shapeChunk = 16 << 10
+
+// conn paces its reads and writes, and resets itself after resetAfter bytes
+// read or written when that is set
+type conn struct {
+	net.Conn
+	s           *Server
+	read, write *pacer
+
+	// moved is updated by the server's reads and writes, which may run at
+	// the same time
+	moved      atomic.Int64
+	resetAfter int64
+	resetOnce  sync.Once
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	if c.read != nil && len(p) > shapeChunk {
+		p = p[:shapeChunk]
+	}
+	if c.resetAfter > 0 {
+		left := c.resetAfter - c.moved.Load()
+		if left <= 0 {
+			return 0, c.reset()
+		}
+		if int64(len(p)) > left {
+			p = p[:left]
+		}
+	}
+	n, err := c.Conn.Read(p)
+	c.moved.Add(int64(n))
+	if c.read != nil {
+		// holding back the next read fills the socket buffers, and TCP
+		// slows the sender down to match
+		c.read.wait(n)
+	}
+	return n, err
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	var total int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > shapeChunk {
+			chunk = chunk[:shapeChunk]
+		}
+		if c.resetAfter > 0 && c.moved.Load()+int64(len(chunk)) >= c.resetAfter {
+			return total, c.reset()
+		}
+		if c.write != nil {
+			c.write.wait(len(chunk))
+		}
+
+		n, err := c.Conn.Write(chunk)
+		total += n
+		c.moved.Add(int64(n))
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// reset closes the connection with a RST rather than an orderly FIN, the
+// way a middlebox dropping state does. A read and a write may both reach the
+// threshold; the connection is only reset, and counted, once.
+func (c *conn) reset() error {
+	c.resetOnce.Do(func() {
+		c.s.resets.Add(1)
+		if tc, ok := c.Conn.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+		c.Conn.Close()
+	})
+	return net.ErrClosed
+}
+
+// pacerSlack is how far behind schedule a pacer may fall and still catch up.
+// Sleeps overshoot, and without it every overshoot would be lost for good
+// and the rate would come out low; beyond it the connection was idle, and
+// idle time is not saved up to let a later burst through faster.
+const pacerSlack = 10 * time.Millisecond
+
+// pacer spaces out bytes to hold a rate
+type pacer struct {
+	bytesPerSecond float64
+	next           time.Time
+}
+
+func (p *pacer) wait(n int) {
+	if now := time.Now(); now.Sub(p.next) > pacerSlack {
+		p.next = now
+	}
+	p.next = p.next.Add(time.Duration(float64(n) / p.bytesPerSecond * float64(time.Second)))
+	time.Sleep(time.Until(p.next))
+}
@@ -0,0 +1,270 @@
+// Package config lets every command line option also come from the
+// environment or a YAML file, so a deployment can set them once rather than
+// on every command line. A flag given on the command line wins over its
+// LIBRESPEED_* environment variable, which wins over the file, which wins
+// over the default.
+//
+// The file maps option names to values, as they are spelled on the command
+// line without the dashes. A subcommand's options go in a section named after
+// it, and named profiles override any of them:
+//
+//	server: [52, 53]
+//	no-icmp: true
+//	telemetry-level: disabled
+//	exporter:
+//	  listen: ":9469"
+//	profiles:
+//	  lte:
+//	    max-bytes: 200M
+//	    duration: 10
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+// EnvPrefix starts the name of every option's environment variable
+const EnvPrefix = "LIBRESPEED_"
+
+// profilesKey holds the named profiles in the file
+const profilesKey = "profiles"
+
+// Setup gives every option of app and its subcommands an environment
+// variable, and has the config file read before any of them runs. The app
+// must define the --config and --profile flags.
+func Setup(app *cli.App) {
+	addEnvVars("", app.Flags)
+	app.Before = load("", app.Flags, app.Commands)
+	for _, cmd := range app.Commands {
+		addEnvVars(cmd.Name, cmd.Flags)
+		cmd.Before = load(cmd.Name, cmd.Flags, nil)
+	}
+}
+
+// EnvVar returns the environment variable of an option, prefixed with its
+// subcommand's name when it has one: LIBRESPEED_MAX_BYTES,
+// LIBRESPEED_EXPORTER_LISTEN
+func EnvVar(command, option string) string {
+	name := option
+	if command != "" {
+		name = command + "_" + option
+	}
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// DefaultPath returns where the config file is read from when --config is
+// not given: $XDG_CONFIG_HOME/librespeed-cli/config.yaml, or the platform's
+// equivalent
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "librespeed-cli", "config.yaml"), nil
+}
+
+// addEnvVars puts an option's LIBRESPEED_* variable ahead of any variable it
+// already reads
+func addEnvVars(command string, flags []cli.Flag) {
+	for _, f := range flags {
+		name := f.Names()[0]
+		if name == defs.OptionHelp || name == defs.OptionVersion {
+			continue
+		}
+		env := []string{EnvVar(command, name)}
+		switch f := f.(type) {
+		case *cli.BoolFlag:
+			f.EnvVars = append(env, f.EnvVars...)
+		case *cli.StringFlag:
+			f.EnvVars = append(env, f.EnvVars...)
+		case *cli.IntFlag:
+			f.EnvVars = append(env, f.EnvVars...)
+		case *cli.IntSliceFlag:
+			f.EnvVars = append(env, f.EnvVars...)
+		case *cli.Float64Flag:
+			f.EnvVars = append(env, f.EnvVars...)
+		case *cli.DurationFlag:
+			f.EnvVars = append(env, f.EnvVars...)
+		}
+	}
+}
+
+// Error is a config file that cannot be read or applied
+type Error struct {
+	err error
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// load returns the Before func that sets the options of one command, or of
+// the app for "", from the config file
+func load(command string, flags []cli.Flag, commands []*cli.Command) cli.BeforeFunc {
+	return func(c *cli.Context) error {
+		if err := apply(c, command, flags, commands); err != nil {
+			return &Error{err: err}
+		}
+		return nil
+	}
+}
+
+// apply sets the options the config file gives, where neither the command
+// line nor the environment has set them
+func apply(c *cli.Context, command string, flags []cli.Flag, commands []*cli.Command) error {
+	values, path, err := read(c)
+	if err != nil || values == nil {
+		return err
+	}
+	if command != "" {
+		section, ok := values[command].(map[string]any)
+		if !ok {
+			if _, present := values[command]; present {
+				return fmt.Errorf("%s: %s must be a section of options", path, command)
+			}
+			return nil
+		}
+		values = section
+	}
+
+	for key, value := range values {
+		if command == "" && (key == profilesKey || slices.ContainsFunc(commands, func(cmd *cli.Command) bool { return cmd.Name == key })) {
+			continue
+		}
+		if lookup(flags, key) == nil || key == defs.OptionConfig || key == defs.OptionProfile {
+			return fmt.Errorf("%s: unknown option %q", path, key)
+		}
+		if c.IsSet(key) {
+			continue
+		}
+		if err := set(c, key, value); err != nil {
+			return fmt.Errorf("%s: option %q: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// read reads the config file with the selected profile applied over it. No
+// file at the default path is no config; at a path that was given, an error.
+func read(c *cli.Context) (map[string]any, string, error) {
+	path := c.String(defs.OptionConfig)
+	explicit := path != ""
+	if !explicit {
+		var err error
+		if path, err = DefaultPath(); err != nil {
+			return nil, "", nil
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		if profile := c.String(defs.OptionProfile); profile != "" {
+			return nil, path, fmt.Errorf("profile %q given, but there is no config file at %s", profile, path)
+		}
+		return nil, path, nil
+	}
+	if err != nil {
+		return nil, path, err
+	}
+
+	var values map[string]any
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return nil, path, fmt.Errorf("%s: %w", path, err)
+	}
+
+	profiles, _ := values[profilesKey].(map[string]any)
+	if _, present := values[profilesKey]; present && profiles == nil {
+		return nil, path, fmt.Errorf("%s: %s must map profile names to options", path, profilesKey)
+	}
+	if name := c.String(defs.OptionProfile); name != "" {
+		profile, ok := profiles[name].(map[string]any)
+		if !ok {
+			names := make([]string, 0, len(profiles))
+			for n := range profiles {
+				names = append(names, n)
+			}
+			slices.Sort(names)
+			return nil, path, fmt.Errorf("%s: no profile %q, the profiles are: %s", path, name, strings.Join(names, ", "))
+		}
+		values = merge(values, profile)
+	}
+	return values, path, nil
+}
+
+// merge returns base with over's values in place of its own. Sections are
+// merged rather than replaced, so a profile can change one option of a
+// subcommand.
+func merge(base, over map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		if sub, ok := v.(map[string]any); ok {
+			if baseSub, ok := merged[k].(map[string]any); ok {
+				v = merge(baseSub, sub)
+			}
+		}
+		merged[k] = v
+	}
+	return merged
+}
+
+// lookup returns the flag an option name or alias belongs to
+func lookup(flags []cli.Flag, name string) cli.Flag {
+	for _, f := range flags {
+		if slices.Contains(f.Names(), name) {
+			return f
+		}
+	}
+	return nil
+}
+
+// set sets an option from a YAML value, as if it had been given on the
+// command line. A list sets an option that can be given more than once.
+func set(c *cli.Context, name string, value any) error {
+	if list, ok := value.([]any); ok {
+		for _, v := range list {
+			if err := set(c, name, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	case int:
+		s = strconv.Itoa(v)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("unsupported value %v", v)
+	}
+	if err := c.Set(name, s); err != nil {
+		return fmt.Errorf("invalid value %q", s)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+// got is what an app's actions saw
+type got struct {
+	json     string
+	server   []int
+	simple   bool
+	interval time.Duration
+	listen   string
+}
+
+// run runs a small app, set up like the real one, with args
+func run(t *testing.T, args ...string) (got, error) {
+	t.Helper()
+
+	var g got
+	app := &cli.App{
+		Name: "librespeed-cli",
+		Flags: []cli.Flag{
+			&cli.StringFlag{Name: defs.OptionConfig},
+			&cli.StringFlag{Name: defs.OptionProfile},
+			&cli.StringFlag{Name: defs.OptionLocalJSON},
+			&cli.IntSliceFlag{Name: defs.OptionServer},
+			&cli.BoolFlag{Name: defs.OptionSimple},
+			&cli.DurationFlag{Name: defs.OptionInterval},
+		},
+		Action: func(c *cli.Context) error {
+			g.json = c.String(defs.OptionLocalJSON)
+			g.server = c.IntSlice(defs.OptionServer)
+			g.simple = c.Bool(defs.OptionSimple)
+			g.interval = c.Duration(defs.OptionInterval)
+			return nil
+		},
+		Commands: []*cli.Command{{
+			Name:  "serve",
+			Flags: []cli.Flag{&cli.StringFlag{Name: defs.OptionListen, Value: ":8989"}},
+			Action: func(c *cli.Context) error {
+				g.listen = c.String(defs.OptionListen)
+				g.json = c.String(defs.OptionLocalJSON)
+				return nil
+			},
+		}},
+	}
+	Setup(app)
+
+	err := app.Run(append([]string{"librespeed-cli"}, args...))
+	return g, err
+}
+
+func TestPrecedence(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(`
+local-json: from-file.json
+server: [52, 53]
+interval: 30m
+serve:
+  listen: ":9000"
+profiles:
+  lte:
+    simple: true
+    server: [7]
+    serve:
+      listen: ":9001"
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want got
+	}{
+		{
+			name: "defaults",
+			want: got{},
+		},
+		{
+			name: "file",
+			args: []string{"--config", file},
+			want: got{json: "from-file.json", server: []int{52, 53}, interval: 30 * time.Minute},
+		},
+		{
+			name: "file from the environment",
+			env:  map[string]string{"LIBRESPEED_CONFIG": file},
+			want: got{json: "from-file.json", server: []int{52, 53}, interval: 30 * time.Minute},
+		},
+		{
+			name: "env beats file",
+			env:  map[string]string{"LIBRESPEED_LOCAL_JSON": "from-env.json", "LIBRESPEED_SERVER": "1,2"},
+			args: []string{"--config", file},
+			want: got{json: "from-env.json", server: []int{1, 2}, interval: 30 * time.Minute},
+		},
+		{
+			name: "flag beats env",
+			env:  map[string]string{"LIBRESPEED_LOCAL_JSON": "from-env.json"},
+			args: []string{"--config", file, "--local-json", "from-flag.json", "--server", "9"},
+			want: got{json: "from-flag.json", server: []int{9}, interval: 30 * time.Minute},
+		},
+		{
+			name: "profile",
+			args: []string{"--config", file, "--profile", "lte"},
+			want: got{json: "from-file.json", server: []int{7}, simple: true, interval: 30 * time.Minute},
+		},
+		{
+			name: "subcommand",
+			args: []string{"--config", file, "serve"},
+			want: got{json: "from-file.json", listen: ":9000"},
+		},
+		{
+			name: "subcommand profile",
+			args: []string{"--config", file, "--profile", "lte", "serve"},
+			want: got{json: "from-file.json", listen: ":9001"},
+		},
+		{
+			name: "subcommand env",
+			env:  map[string]string{"LIBRESPEED_SERVE_LISTEN": ":9002"},
+			args: []string{"--config", file, "serve"},
+			want: got{json: "from-file.json", listen: ":9002"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			g, err := run(t, tt.args...)
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if !reflect.DeepEqual(g, tt.want) {
+				t.Errorf("got %+v, want %+v", g, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string
+		args    []string
+		wantErr string
+	}{
+		{"unknown option", "nope: 1\n", nil, `unknown option "nope"`},
+		{"invalid value", "interval: soon\n", nil, `option "interval": invalid value "soon"`},
+		{"unknown profile", "profiles:\n  lte: {}\n", []string{"--profile", "office"}, `no profile "office", the profiles are: lte`},
+		{"config in config", "config: other.yaml\n", nil, `unknown option "config"`},
+		{"not YAML", "[", nil, "yaml"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".yaml")
+			if err := os.WriteFile(file, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := run(t, append([]string{"--config", file}, tt.args...)...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := run(t, "--config", filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("a missing --config file was not an error")
+	}
+	if _, err := run(t, "--profile", "lte"); err == nil {
+		t.Error("a profile without a config file was not an error")
+	}
+}
+
+func TestEnvVar(t *testing.T) {
+	if got := EnvVar("", defs.OptionMaxBytes); got != "LIBRESPEED_MAX_BYTES" {
+		t.Errorf("EnvVar = %q", got)
+	}
+	if got := EnvVar("exporter", defs.OptionCacheTTL); got != "LIBRESPEED_EXPORTER_CACHE_TTL" {
+		t.Errorf("EnvVar = %q", got)
+	}
+}
@@ -0,0 +1,222 @@
+package defs_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/backend/backendtest"
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+// These tests run the measurement engine against links shaped to known
+// conditions, and check the numbers it reports rather than only that it
+// reports some. They take seconds each, so they are skipped with -short.
+
+// within fails the test unless got is within tolerance, a fraction, of want
+func within(t *testing.T, what string, got, want, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-want) > want*tolerance {
+		t.Errorf("%s = %.2f, want %.2f ± %.0f%%", what, got, want, tolerance*100)
+	}
+}
+
+func TestShapedDownloadRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("shaped transfers take seconds")
+	}
+
+	srv := backendtest.New(t, backendtest.Config{Mbps: 25})
+	s := srv.Entry(1)
+
+	// the limit is per connection, so two streams should measure twice it
+	res, err := s.DownloadContext(context.Background(), defs.TransferOptions{
+		Silent:   true,
+		Requests: 2,
+		Duration: 3 * time.Second,
+		Warmup:   time.Second,
+		Chunks:   100,
+	})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	within(t, "download Mbps", res.Mbps, 50, 0.05)
+}
+
+func TestShapedUploadRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("shaped transfers take seconds")
+	}
+
+	srv := backendtest.New(t, backendtest.Config{Mbps: 25})
+	s := srv.Entry(1)
+
+	res, err := s.UploadContext(context.Background(), defs.TransferOptions{
+		Silent:     true,
+		Requests:   2,
+		Duration:   4 * time.Second,
+		Warmup:     2 * time.Second,
+		UploadSize: 256,
+	})
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	within(t, "upload Mbps", res.Mbps, 50, 0.05)
+}
+
+func TestAddedLatencyShowsInPing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("pings over a delayed link take seconds")
+	}
+
+	srv := backendtest.New(t, backendtest.Config{Latency: 20 * time.Millisecond})
+	s := srv.Entry(1)
+
+	ping, jitter, err := s.PingAndJitter(6)
+	if err != nil {
+		t.Fatalf("PingAndJitter: %v", err)
+	}
+	// loopback and scheduling only ever add to the simulated latency
+	if ping < 20 || ping > 25 {
+		t.Errorf("ping = %.2f ms, want 20-25 ms", ping)
+	}
+	if jitter > 2 {
+		t.Errorf("jitter = %.2f ms on a steady link, want under 2 ms", jitter)
+	}
+}
+
+func TestJitterShowsInPing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("pings over a delayed link take seconds")
+	}
+
+	srv := backendtest.New(t, backendtest.Config{Latency: 10 * time.Millisecond, Jitter: 20 * time.Millisecond, Seed: 1})
+	s := srv.Entry(1)
+
+	ping, jitter, err := s.PingAndJitter(12)
+	if err != nil {
+		t.Fatalf("PingAndJitter: %v", err)
+	}
+	// the delay is uniform over 10-30 ms
+	if ping < 10 || ping > 32 {
+		t.Errorf("ping = %.2f ms, want within the 10-30 ms the link adds", ping)
+	}
+	if jitter < 1 || jitter > 20 {
+		t.Errorf("jitter = %.2f ms, want a clear share of the 20 ms spread", jitter)
+	}
+}
+
+func TestInjectedErrorsAreCounted(t *testing.T) {
+	srv := backendtest.New(t, backendtest.Config{ErrorRate: 0.5, Seed: 1})
+	s := srv.Entry(1)
+
+	res, err := s.DownloadContext(context.Background(), defs.TransferOptions{
+		Silent:        true,
+		Requests:      2,
+		Duration:      time.Second,
+		MaxErrorRatio: 0.1,
+		Chunks:        1,
+	})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if got := res.Requests.Errors["503"]; got == 0 || got > srv.InjectedErrors() {
+		t.Errorf("counted %d 503s, want some, and no more than the %d injected", got, srv.InjectedErrors())
+	}
+	if !res.TooManyErrors {
+		t.Errorf("TooManyErrors is not set at an error ratio of %.2f", res.Requests.ErrorRatio())
+	}
+}
+
+// A payload the server rejected was not taken, and is not throughput
+func TestRejectedUploadsAreNotThroughput(t *testing.T) {
+	srv := backendtest.New(t, backendtest.Config{ErrorRate: 1})
+	s := srv.Entry(1)
+
+	res, err := s.UploadContext(context.Background(), defs.TransferOptions{
+		Silent:     true,
+		Requests:   2,
+		Duration:   time.Second,
+		UploadSize: 256,
+	})
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if srv.InjectedErrors() == 0 {
+		t.Fatal("no upload was rejected")
+	}
+	// the requests cut short at the end were neither accepted nor rejected,
+	// and may leave a little behind
+	if res.Mbps > 1 {
+		t.Errorf("upload Mbps = %.2f with every upload rejected, want about 0", res.Mbps)
+	}
+}
+
+func TestInjectedResetsAreCounted(t *testing.T) {
+	srv := backendtest.New(t, backendtest.Config{ResetRate: 1})
+	s := srv.Entry(1)
+
+	res, err := s.DownloadContext(context.Background(), defs.TransferOptions{
+		Silent:   true,
+		Requests: 1,
+		Duration: time.Second,
+		Chunks:   4,
+	})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if srv.InjectedResets() == 0 || res.Requests.Failed == 0 {
+		t.Errorf("%d reset(s) injected, %d request(s) failed, want both", srv.InjectedResets(), res.Requests.Failed)
+	}
+	// what arrived before each reset still crossed the link
+	if res.Bytes == 0 {
+		t.Error("nothing counted from the connections before they were reset")
+	}
+}
+
+// Resets count what the client sends too, so an upload is cut short like a
+// download
+func TestInjectedResetsCutUploads(t *testing.T) {
+	srv := backendtest.New(t, backendtest.Config{ResetRate: 1, ResetAfter: 1 << 20})
+	s := srv.Entry(1)
+
+	res, err := s.UploadContext(context.Background(), defs.TransferOptions{
+		Silent:     true,
+		Requests:   1,
+		Duration:   time.Second,
+		UploadSize: 256,
+	})
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if srv.InjectedResets() == 0 || res.Requests.Failed == 0 {
+		t.Errorf("%d reset(s) injected, %d request(s) failed, want both", srv.InjectedResets(), res.Requests.Failed)
+	}
+	// a connection carries the first few of the 256 KiB uploads before the
+	// MiB it may move is spent
+	if res.Requests.Succeeded > 4*res.Requests.Failed {
+		t.Errorf("%d upload(s) succeeded for %d reset, want at most 4 per connection", res.Requests.Succeeded, res.Requests.Failed)
+	}
+}
+
+func TestPingStatsShowSpread(t *testing.T) {
+	srv := backendtest.New(t, backendtest.Config{Latency: 10 * time.Millisecond, Jitter: 20 * time.Millisecond, Seed: 1})
+	s := srv.Entry(1)
+
+	res, err := s.HTTPPing(context.Background(), 12)
+	if err != nil {
+		t.Fatalf("HTTPPing: %v", err)
+	}
+	// the first request sets the connection up and is left out
+	if len(res.Samples) != 11 {
+		t.Fatalf("got %d samples, want 11", len(res.Samples))
+	}
+	stats := res.Stats()
+	if stats.Min < 10 || stats.Max > 32 || stats.Max-stats.Min < 5 {
+		t.Errorf("stats = %+v, want a spread within the 10-30 ms the link adds", stats)
+	}
+	if stats.Min > stats.Median || stats.Median > stats.P95 || stats.P95 > stats.Max {
+		t.Errorf("stats = %+v, want min <= median <= p95 <= max", stats)
+	}
+}
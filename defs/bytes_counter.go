@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand/v2"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -16,6 +17,23 @@ type BytesCounter struct {
 	payload    []byte
 	mebi       bool
 	uploadSize int
+
+	// limit closes limitReached once total reaches it; zero is no limit
+	limit        uint64
+	limitReached chan struct{}
+	limitOnce    sync.Once
+
+	// points holds the running total at the end of each sampling interval
+	mu           sync.Mutex
+	points       []samplePoint
+	stopSampling chan struct{}
+	sampling     chan struct{}
+}
+
+// samplePoint is the running total at some time after Start
+type samplePoint struct {
+	elapsed time.Duration
+	total   uint64
 }
 
 func NewCounter() *BytesCounter {
@@ -25,10 +43,38 @@ func NewCounter() *BytesCounter {
 // Write implements io.Writer
 func (c *BytesCounter) Write(p []byte) (int, error) {
 	n := len(p)
-	c.total.Add(uint64(n))
+	if total := c.total.Add(uint64(n)); c.limit > 0 && total >= c.limit {
+		c.limitOnce.Do(func() { close(c.limitReached) })
+	}
 	return n, nil
 }
 
+// Uncount takes back n bytes counted earlier, for a request whose data turned
+// out not to be throughput. The total may then drop below what was sampled
+// before, and the rates over the intervals since read as 0.
+func (c *BytesCounter) Uncount(n uint64) {
+	if n > 0 {
+		c.total.Add(^(n - 1))
+	}
+}
+
+// SetLimit makes LimitReached fire once `limit` bytes have been counted. The
+// counter only observes the transfer, so whatever the requests in flight move
+// before they are cancelled still lands on top of it. Zero is no limit.
+func (c *BytesCounter) SetLimit(limit uint64) {
+	c.limit = limit
+	c.limitReached = make(chan struct{})
+}
+
+// LimitReached is closed once the limit set by SetLimit is reached. Without a
+// limit it is nil, and never fires in a select.
+func (c *BytesCounter) LimitReached() <-chan struct{} {
+	if c.limit == 0 {
+		return nil
+	}
+	return c.limitReached
+}
+
 // SetBase sets the base for dividing bytes into megabyte or mebibyte
 func (c *BytesCounter) SetMebi(mebi bool) {
 	c.mebi = mebi
@@ -46,19 +92,27 @@ func (c *BytesCounter) AvgBytes() float64 {
 
 // AvgMbps returns the average mbits/second
 func (c *BytesCounter) AvgMbps() float64 {
-	var base float64 = 125000
+	return c.AvgBytes() / c.mbpsBase()
+}
+
+// mbpsBase returns the bytes/second in one mbit/second
+func (c *BytesCounter) mbpsBase() float64 {
 	if c.mebi {
-		base = 131072
+		return 131072
 	}
-	return c.AvgBytes() / base
+	return 125000
 }
 
 // AvgHumanize returns the average bytes/kilobytes/megabytes/gigabytes (or bytes/kibibytes/mebibytes/gibibytes) per second
 func (c *BytesCounter) AvgHumanize() string {
-	val := c.AvgBytes()
+	return humanizeBytes(c.AvgBytes(), c.mebi)
+}
 
+// humanizeBytes formats a rate in bytes/second as bytes/kilobytes/megabytes/gigabytes
+// (or bytes/kibibytes/mebibytes/gibibytes) per second
+func humanizeBytes(val float64, mebi bool) string {
 	var base float64 = 1000
-	if c.mebi {
+	if mebi {
 		base = 1024
 	}
 
@@ -88,6 +142,99 @@ func (c *BytesCounter) Start() {
 	c.start = time.Now()
 }
 
+// StartSampling records the rate over every `interval` until StopSampling is
+// called. An average over the whole transfer hides a link that bursts and
+// then throttles; the samples are what show it.
+func (c *BytesCounter) StartSampling(interval time.Duration) {
+	c.stopSampling = make(chan struct{})
+	c.sampling = make(chan struct{})
+
+	go func() {
+		defer close(c.sampling)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.stopSampling:
+				return
+			case now := <-ticker.C:
+				// keep the time that actually passed rather than the nominal
+				// interval: a late tick would otherwise read as a burst
+				p := samplePoint{elapsed: now.Sub(c.start), total: c.total.Load()}
+				c.mu.Lock()
+				c.points = append(c.points, p)
+				c.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// StopSampling ends sampling and waits for the sampler to finish. The last,
+// partial interval is dropped rather than recorded as a short one.
+func (c *BytesCounter) StopSampling() {
+	if c.stopSampling == nil {
+		return
+	}
+	close(c.stopSampling)
+	<-c.sampling
+	c.stopSampling = nil
+}
+
+// Samples returns the rate in mbits/second over each sampling interval
+func (c *BytesCounter) Samples() []float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	base := c.mbpsBase()
+	ret := make([]float64, len(c.points))
+	var last samplePoint
+	for i, p := range c.points {
+		ret[i] = float64(since(p.total, last.total)) / (p.elapsed - last.elapsed).Seconds() / base
+		last = p
+	}
+	return ret
+}
+
+// SamplesWithin returns how many sampling intervals it takes to cover `d`
+// from Start, or every interval recorded if they do not reach that far
+func (c *BytesCounter) SamplesWithin(d time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, p := range c.points {
+		if p.elapsed >= d {
+			return i + 1
+		}
+	}
+	return len(c.points)
+}
+
+// AvgMbpsAfter returns the average mbits/second over everything after the
+// first `samples` sampling intervals, and how long those intervals took.
+// Skipping none, or every interval recorded, is the same as AvgMbps: what
+// follows the last interval is too short a sliver to stand for the rate.
+func (c *BytesCounter) AvgMbpsAfter(samples int) (float64, time.Duration) {
+	c.mu.Lock()
+	var skipped samplePoint
+	if samples > 0 && samples < len(c.points) {
+		skipped = c.points[samples-1]
+	}
+	c.mu.Unlock()
+
+	bytes := float64(since(c.total.Load(), skipped.total))
+	return bytes / (time.Since(c.start) - skipped.elapsed).Seconds() / c.mbpsBase(), skipped.elapsed
+}
+
+// since returns how many bytes a running total grew by from `then` to `now`,
+// or 0 where bytes taken back by Uncount made it shrink
+func since(now, then uint64) uint64 {
+	if now < then {
+		return 0
+	}
+	return now - then
+}
+
 // Total returns the total bytes read/written
 func (c *BytesCounter) Total() uint64 {
 	return c.total.Load()
@@ -3,6 +3,7 @@ package defs
 import (
 	"sync"
 	"testing"
+	"time"
 )
 
 // TestBytesCounterConcurrentAccess exercises the pattern the counter is used
@@ -72,3 +73,78 @@ func TestBytesCounterConcurrentAccess(t *testing.T) {
 		t.Errorf("Total() = %d, want %d (lost updates indicate a broken counter)", got, wantTotal)
 	}
 }
+
+// A counter written to at a steady rate must record one sample per interval,
+// each close to that rate, and nothing once sampling has stopped.
+func TestBytesCounterSamples(t *testing.T) {
+	const interval = 20 * time.Millisecond
+
+	c := NewCounter()
+	c.Start()
+	c.StartSampling(interval)
+
+	chunk := make([]byte, 12500) // 0.1 Mbit, so 5 Mbps at one chunk per interval
+	ticker := time.NewTicker(interval)
+	for i := 0; i < 10; i++ {
+		<-ticker.C
+		c.Write(chunk)
+	}
+	ticker.Stop()
+	c.StopSampling()
+
+	samples := c.Samples()
+	if len(samples) < 5 || len(samples) > 11 {
+		t.Fatalf("got %d samples over 10 intervals", len(samples))
+	}
+	if median := NewThroughputStats(samples).Median; median < 2.5 || median > 10 {
+		t.Errorf("median sample = %.2f Mbps, want about 5", median)
+	}
+
+	c.Write(chunk)
+	time.Sleep(2 * interval)
+	if got := len(c.Samples()); got != len(samples) {
+		t.Errorf("sampling continued after StopSampling: %d samples, was %d", got, len(samples))
+	}
+}
+
+func TestBytesCounterLimit(t *testing.T) {
+	c := NewCounter()
+	if c.LimitReached() != nil {
+		t.Fatal("LimitReached is set without a limit")
+	}
+
+	c.SetLimit(10)
+	c.Write(make([]byte, 6))
+	select {
+	case <-c.LimitReached():
+		t.Fatal("LimitReached fired below the limit")
+	default:
+	}
+
+	// crossing it again must not close the channel twice
+	c.Write(make([]byte, 6))
+	c.Write(make([]byte, 6))
+	select {
+	case <-c.LimitReached():
+	default:
+		t.Fatal("LimitReached did not fire past the limit")
+	}
+}
+
+func TestBytesCounterUncount(t *testing.T) {
+	c := NewCounter()
+	c.Start()
+	c.Write(make([]byte, 100))
+	c.points = append(c.points, samplePoint{elapsed: time.Second, total: 100})
+
+	// the bytes taken back were sampled already; the next interval reads as
+	// 0 rather than wrapping around
+	c.Uncount(60)
+	c.points = append(c.points, samplePoint{elapsed: 2 * time.Second, total: c.Total()})
+	if got := c.Total(); got != 40 {
+		t.Errorf("Total() = %d, want 40", got)
+	}
+	if got := c.Samples(); got[1] != 0 {
+		t.Errorf("Samples() = %v, want the interval after Uncount at 0", got)
+	}
+}
@@ -0,0 +1,160 @@
+package defs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/output"
+)
+
+const (
+	// loadedPingInterval is the pause between pings while a transfer runs
+	loadedPingInterval = 250 * time.Millisecond
+	// idlePingCount is how many pings make the baseline taken before a
+	// transfer starts, the first of which is discarded as the handshake
+	idlePingCount = 4
+)
+
+// BufferbloatGrade grades how much latency a saturated link adds, in ms. The
+// bands follow the ones commonly used for bufferbloat tests: beyond 30 ms
+// interactive traffic starts to notice, beyond 200 ms calls break up.
+func BufferbloatGrade(added float64) string {
+	switch {
+	case added < 5:
+		return "A+"
+	case added < 30:
+		return "A"
+	case added < 60:
+		return "B"
+	case added < 200:
+		return "C"
+	case added < 400:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+// latencyProbe pings a server over a connection of its own while a transfer
+// saturates the link, so the queueing the transfer causes shows up in the
+// round-trip times. Sharing the transfer's connections would measure how
+// long a ping waits behind the data instead.
+type latencyProbe struct {
+	client *http.Client
+	req    *http.Request
+	idle   float64
+
+	mu      sync.Mutex
+	rtts    []float64
+	stopped chan struct{}
+}
+
+// newLatencyProbe connects to the server's ping URL and takes the idle
+// baseline the loaded pings are compared with. Measuring the baseline over
+// the same connection and with the same method keeps HTTP overhead out of
+// the difference, which comparing with an ICMP ping would not.
+func (s *Server) newLatencyProbe() (*latencyProbe, error) {
+	u, err := s.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, s.PingURL)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	p := &latencyProbe{client: s.separateClient(), req: req}
+
+	var pings []float64
+	for i := 0; i < idlePingCount; i++ {
+		rtt, err := p.ping(context.Background())
+		if err != nil {
+			p.client.CloseIdleConnections()
+			return nil, err
+		}
+		pings = append(pings, rtt)
+	}
+	// discard first result due to handshake overhead
+	p.idle = getAvg(pings[1:])
+
+	return p, nil
+}
+
+// separateClient returns a client with the server's settings but a
+// connection pool of its own
+func (s *Server) separateClient() *http.Client {
+	client := *s.httpClient()
+	switch t := client.Transport.(type) {
+	case nil:
+		client.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		client.Transport = t.Clone()
+	}
+	return &client
+}
+
+// ping makes one request and returns its round-trip time in ms
+func (p *latencyProbe) ping(ctx context.Context) (float64, error) {
+	start := time.Now()
+	resp, err := p.client.Do(p.req.Clone(ctx))
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return rttMillis(time.Since(start)), nil
+}
+
+// start pings until ctx is cancelled
+func (p *latencyProbe) start(ctx context.Context) {
+	p.stopped = make(chan struct{})
+
+	go func() {
+		defer close(p.stopped)
+		for {
+			rtt, err := p.ping(ctx)
+			if err != nil {
+				// the ping still in flight when the transfer ends is cut
+				// short, so it says nothing about the latency
+				if !errors.Is(err, context.Canceled) {
+					output.WriteDebug("Failed to ping under load: %s\n", err)
+				}
+			} else {
+				p.mu.Lock()
+				p.rtts = append(p.rtts, rtt)
+				p.mu.Unlock()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(loadedPingInterval):
+			}
+		}
+	}()
+}
+
+// avg returns the average loaded ping so far
+func (p *latencyProbe) avg() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.rtts) == 0 {
+		return 0
+	}
+	return getAvg(p.rtts)
+}
+
+// wait waits for the pinger to stop and returns every loaded ping
+func (p *latencyProbe) wait() []float64 {
+	<-p.stopped
+	p.client.CloseIdleConnections()
+	return p.rtts
+}
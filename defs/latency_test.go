@@ -0,0 +1,54 @@
+package defs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBufferbloatGrade(t *testing.T) {
+	cases := []struct {
+		added float64
+		want  string
+	}{
+		{-1, "A+"},
+		{0, "A+"},
+		{4.99, "A+"},
+		{5, "A"},
+		{29, "A"},
+		{30, "B"},
+		{60, "C"},
+		{199, "C"},
+		{200, "D"},
+		{400, "F"},
+		{2000, "F"},
+	}
+
+	for _, c := range cases {
+		if got := BufferbloatGrade(c.added); got != c.want {
+			t.Errorf("BufferbloatGrade(%v) = %q, want %q", c.added, got, c.want)
+		}
+	}
+}
+
+// Pings under load must leave the transfer's own connection pool alone, or
+// they would queue behind the transfer's requests rather than the link.
+func TestLatencyProbeUsesSeparateConnections(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	s := &Server{Server: ts.URL, PingURL: "/", Client: &http.Client{Transport: transport}}
+
+	p, err := s.newLatencyProbe()
+	if err != nil {
+		t.Fatalf("newLatencyProbe returned error: %v", err)
+	}
+	if p.client.Transport == http.RoundTripper(transport) {
+		t.Error("probe shares the server's transport")
+	}
+	if p.idle <= 0 || p.idle > float64(time.Second/time.Millisecond) {
+		t.Errorf("idle ping = %v ms, want a measured round trip", p.idle)
+	}
+}
@@ -0,0 +1,177 @@
+package defs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	probing "github.com/prometheus-community/pro-bing"
+
+	"github.com/librespeed/speedtest-cli/output"
+)
+
+// LossOptions configures a packet loss test
+type LossOptions struct {
+	// Count is how many probes make up the burst
+	Count int
+	// Interval is the time between the start of one probe and the next
+	Interval time.Duration
+	// Timeout is how long a probe may go unanswered before it counts as lost
+	Timeout time.Duration
+	// Source and Network bind the ICMP probes like the ping test's
+	Source  string
+	Network string
+}
+
+// Loss is what a packet loss test counted
+type Loss struct {
+	// Method is MethodICMP, or MethodHTTP where ICMP is not available
+	Method   string
+	Sent     int
+	Received int
+}
+
+// Percent returns the share of the probes that went unanswered, 0 to 100
+func (l *Loss) Percent() float64 {
+	if l.Sent == 0 {
+		return 0
+	}
+	lost := max(l.Sent-l.Received, 0)
+	return float64(lost) * 100 / float64(l.Sent)
+}
+
+// PacketLoss sends a burst of probes to the server and counts how many go
+// unanswered. ICMP echoes are used unless the server is known not to answer
+// them, in which case the probes are HTTP requests to its ping URL, and only
+// those that time out count as lost: TCP retransmits what the path drops, so
+// over HTTP loss shows up as probes delayed past the timeout.
+func (s *Server) PacketLoss(ctx context.Context, opts LossOptions) (*Loss, error) {
+	if opts.Count <= 0 || opts.Interval <= 0 || opts.Timeout <= 0 {
+		return nil, errors.New("invalid packet loss test settings")
+	}
+
+	t := time.Now()
+	defer func() {
+		s.TLog.Logf("Packet loss test took %s", time.Since(t).String())
+	}()
+
+	if !s.NoICMP {
+		loss, err := s.icmpLoss(ctx, opts)
+		if err == nil || ctx.Err() != nil {
+			return loss, err
+		}
+		output.WriteDebug("Packet loss over ICMP failed: %s\n", err)
+		output.WriteDebug("Will count lost HTTP probes instead\n")
+	}
+	return s.httpLoss(ctx, opts)
+}
+
+// icmpLoss sends the burst as ICMP echoes. No replies at all is taken to mean
+// the server or the path drops ICMP rather than that every packet was lost,
+// the same call the ping test makes.
+func (s *Server) icmpLoss(ctx context.Context, opts LossOptions) (*Loss, error) {
+	u, err := s.GetURL()
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := probing.NewPinger(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	p.SetNetwork(opts.Network)
+	p.Count = opts.Count
+	p.Interval = opts.Interval
+	// the last probe still gets its full timeout to be answered
+	p.Timeout = time.Duration(opts.Count-1)*opts.Interval + opts.Timeout
+	if opts.Source != "" {
+		p.Source = opts.Source
+	}
+	if output.IsDebug() {
+		p.Debug = true
+	}
+	if err := p.RunWithContext(ctx); err != nil {
+		return nil, err
+	}
+
+	stats := p.Statistics()
+	if err := ctx.Err(); err != nil {
+		return &Loss{Method: MethodICMP, Sent: stats.PacketsSent, Received: stats.PacketsRecv}, err
+	}
+	if stats.PacketsRecv == 0 {
+		return nil, fmt.Errorf("no reply to any of %d ICMP echoes", stats.PacketsSent)
+	}
+	return &Loss{Method: MethodICMP, Sent: stats.PacketsSent, Received: stats.PacketsRecv}, nil
+}
+
+// httpLoss sends the burst as HTTP requests to the ping URL, one at a time
+func (s *Server) httpLoss(ctx context.Context, opts LossOptions) (*Loss, error) {
+	u, err := s.GetURL()
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, s.PingURL)
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+
+	loss := &Loss{Method: MethodHTTP}
+	tick := time.NewTicker(opts.Interval)
+	defer tick.Stop()
+	for i := 0; i < opts.Count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return loss, ctx.Err()
+			case <-tick.C:
+			}
+		}
+
+		answered, err := s.lossProbe(ctx, req, opts.Timeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return loss, ctx.Err()
+			}
+			return nil, err
+		}
+		loss.Sent++
+		if answered {
+			loss.Received++
+		}
+	}
+	return loss, nil
+}
+
+// lossProbe makes one HTTP probe. It reports whether it was answered within
+// timeout; failing any other way is an error, not loss.
+func (s *Server) lossProbe(ctx context.Context, req *http.Request, timeout time.Duration) (bool, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := s.httpClient().Do(req.Clone(probeCtx))
+	if err == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			err = checkStatus(resp)
+		}
+	}
+	if err == nil {
+		return true, nil
+	}
+
+	var netErr net.Error
+	if ctx.Err() == nil && (errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()) {
+		output.WriteDebug("HTTP loss probe timed out after %s\n", timeout)
+		return false, nil
+	}
+	return false, err
+}
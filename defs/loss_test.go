@@ -0,0 +1,66 @@
+package defs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLossPercent(t *testing.T) {
+	cases := []struct {
+		loss Loss
+		want float64
+	}{
+		{Loss{}, 0},
+		{Loss{Sent: 50, Received: 50}, 0},
+		{Loss{Sent: 50, Received: 49}, 2},
+		{Loss{Sent: 4, Received: 0}, 100},
+		// a duplicate reply never makes loss negative
+		{Loss{Sent: 4, Received: 5}, 0},
+	}
+
+	for _, c := range cases {
+		if got := c.loss.Percent(); got != c.want {
+			t.Errorf("%+v.Percent() = %g, want %g", c.loss, got, c.want)
+		}
+	}
+}
+
+// Over HTTP a probe that outlives the timeout is lost, and the burst goes on
+func TestPacketLossCountsTimedOutHTTPProbes(t *testing.T) {
+	var n atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n.Add(1)%3 == 0 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}))
+	defer ts.Close()
+
+	s := &Server{Server: ts.URL, PingURL: "/", NoICMP: true}
+	loss, err := s.PacketLoss(context.Background(), LossOptions{Count: 6, Interval: time.Millisecond, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("PacketLoss: %v", err)
+	}
+	if loss.Method != MethodHTTP || loss.Sent != 6 || loss.Received != 4 {
+		t.Errorf("PacketLoss = %+v, want 4 of 6 HTTP probes answered", loss)
+	}
+}
+
+// A server that answers with an error is not losing packets
+func TestPacketLossFailsOnHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	s := &Server{Server: ts.URL, PingURL: "/", NoICMP: true}
+	if loss, err := s.PacketLoss(context.Background(), LossOptions{Count: 3, Interval: time.Millisecond, Timeout: time.Second}); err == nil {
+		t.Errorf("PacketLoss = %+v, want an error", loss)
+	}
+}
@@ -1,48 +1,99 @@
 package defs
 
 const (
-	OptionHelp            = "help"
-	OptionIPv4            = "ipv4"
-	OptionIPv4Alt         = "4"
-	OptionIPv6            = "ipv6"
-	OptionIPv6Alt         = "6"
-	OptionNoDownload      = "no-download"
-	OptionNoUpload        = "no-upload"
-	OptionNoICMP          = "no-icmp"
-	OptionConcurrent      = "concurrent"
-	OptionBytes           = "bytes"
-	OptionMebiBytes       = "mebibytes"
-	OptionDistance        = "distance"
-	OptionShare           = "share"
-	OptionSimple          = "simple"
-	OptionCSV             = "csv"
-	OptionCSVDelimiter    = "csv-delimiter"
-	OptionCSVHeader       = "csv-header"
-	OptionJSON            = "json"
-	OptionJSONStream      = "json-stream"
-	OptionList            = "list"
-	OptionServer          = "server"
-	OptionExclude         = "exclude"
-	OptionServerJSON      = "server-json"
-	OptionSource          = "source"
-	OptionInterface       = "interface"
-	OptionTimeout         = "timeout"
-	OptionChunks          = "chunks"
-	OptionUploadSize      = "upload-size"
-	OptionDuration        = "duration"
-	OptionSecure          = "secure"
-	OptionInsecure        = "insecure"
-	OptionCACert          = "ca-cert"
-	OptionSkipCertVerify  = "skip-cert-verify"
-	OptionNoPreAllocate   = "no-pre-allocate"
-	OptionVersion         = "version"
-	OptionLocalJSON       = "local-json"
-	OptionDebug           = "debug"
-	OptionTelemetryJSON   = "telemetry-json"
-	OptionTelemetryLevel  = "telemetry-level"
-	OptionTelemetryServer = "telemetry-server"
-	OptionTelemetryPath   = "telemetry-path"
-	OptionTelemetryShare  = "telemetry-share"
-	OptionTelemetryExtra  = "telemetry-extra"
-	OptionFwmark          = "fwmark"
+	OptionHelp              = "help"
+	OptionConfig            = "config"
+	OptionProfile           = "profile"
+	OptionIPv4              = "ipv4"
+	OptionIPv4Alt           = "4"
+	OptionIPv6              = "ipv6"
+	OptionIPv6Alt           = "6"
+	OptionDualStack         = "dual-stack"
+	OptionNoDownload        = "no-download"
+	OptionNoUpload          = "no-upload"
+	OptionNoICMP            = "no-icmp"
+	OptionPingCount         = "ping-count"
+	OptionPingSamples       = "ping-samples"
+	OptionConcurrent        = "concurrent"
+	OptionAdaptive          = "adaptive"
+	OptionMaxConcurrent     = "max-concurrent"
+	OptionBytes             = "bytes"
+	OptionMebiBytes         = "mebibytes"
+	OptionDistance          = "distance"
+	OptionShare             = "share"
+	OptionSimple            = "simple"
+	OptionCSV               = "csv"
+	OptionCSVDelimiter      = "csv-delimiter"
+	OptionCSVHeader         = "csv-header"
+	OptionJSON              = "json"
+	OptionJSONStream        = "json-stream"
+	OptionPrometheusFile    = "prometheus-file"
+	OptionInflux            = "influx"
+	OptionInfluxURL         = "influx-url"
+	OptionInfluxOrg         = "influx-org"
+	OptionInfluxBucket      = "influx-bucket"
+	OptionInfluxToken       = "influx-token"
+	OptionList              = "list"
+	OptionServer            = "server"
+	OptionExclude           = "exclude"
+	OptionServerJSON        = "server-json"
+	OptionSource            = "source"
+	OptionInterface         = "interface"
+	OptionTimeout           = "timeout"
+	OptionChunks            = "chunks"
+	OptionUploadSize        = "upload-size"
+	OptionDuration          = "duration"
+	OptionWarmup            = "warmup"
+	OptionAutoWarmup        = "auto-warmup"
+	OptionLoadedLatency     = "loaded-latency"
+	OptionLossCount         = "loss-count"
+	OptionLossInterval      = "loss-interval"
+	OptionConverge          = "converge"
+	OptionConvergeTolerance = "converge-tolerance"
+	OptionMaxBytes          = "max-bytes"
+	OptionMaxPhaseBytes     = "max-phase-bytes"
+	OptionMaxErrorRatio     = "max-error-ratio"
+	OptionSecure            = "secure"
+	OptionInsecure          = "insecure"
+	OptionCACert            = "ca-cert"
+	OptionSkipCertVerify    = "skip-cert-verify"
+	OptionNoPreAllocate     = "no-pre-allocate"
+	OptionVersion           = "version"
+	OptionLocalJSON         = "local-json"
+	OptionDebug             = "debug"
+	OptionTelemetryJSON     = "telemetry-json"
+	OptionTelemetryLevel    = "telemetry-level"
+	OptionTelemetryServer   = "telemetry-server"
+	OptionTelemetryPath     = "telemetry-path"
+	OptionTelemetryShare    = "telemetry-share"
+	OptionTelemetryExtra    = "telemetry-extra"
+	OptionFwmark            = "fwmark"
+	OptionInterval          = "interval"
+	OptionCron              = "cron"
+	OptionRandomDelay       = "random-delay"
+	OptionServerListTTL     = "server-list-ttl"
+	OptionSaveHistory       = "save-history"
+	OptionHistoryFile       = "history-file"
+	OptionMinDownload       = "min-download"
+	OptionMinUpload         = "min-upload"
+	OptionMaxPing           = "max-ping"
+	OptionMaxJitter         = "max-jitter"
+
+	// serve subcommand
+	OptionListen       = "listen"
+	OptionAdvertiseURL = "url"
+	OptionServerName   = "name"
+	OptionServerList   = "server-list"
+	OptionTLSCert      = "tls-cert"
+	OptionTLSKey       = "tls-key"
+
+	// exporter subcommand
+	OptionCacheTTL    = "cache-ttl"
+	OptionMinInterval = "min-interval"
+
+	// history subcommand
+	OptionSince   = "since"
+	OptionUntil   = "until"
+	OptionMatch   = "match"
+	OptionSummary = "summary"
 )
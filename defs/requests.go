@@ -0,0 +1,140 @@
+package defs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// RequestStats counts how the requests of a transfer ended
+type RequestStats struct {
+	Succeeded int
+	// Failed counts requests that got an error or a non-2xx status before the
+	// transfer was over
+	Failed int
+	// Cancelled counts requests still in flight when the transfer ended,
+	// which is how every stream normally finishes
+	Cancelled int
+	// Errors breaks Failed down by cause: the HTTP status code, or "timeout",
+	// "refused", "reset", "eof" or "error" for a request that got none
+	Errors map[string]int
+}
+
+// ErrorRatio returns the share of the requests that finished which failed.
+// Cancelled requests did not get to finish, so they are left out.
+func (r RequestStats) ErrorRatio() float64 {
+	if finished := r.Succeeded + r.Failed; finished > 0 {
+		return float64(r.Failed) / float64(finished)
+	}
+	return 0
+}
+
+// errorSummary lists the causes of the failed requests, most common first,
+// e.g. "503: 4, timeout: 1"
+func (r RequestStats) errorSummary() string {
+	classes := make([]string, 0, len(r.Errors))
+	for class := range r.Errors {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if r.Errors[classes[i]] != r.Errors[classes[j]] {
+			return r.Errors[classes[i]] > r.Errors[classes[j]]
+		}
+		return classes[i] < classes[j]
+	})
+
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%s: %d", class, r.Errors[class])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// requestTally records the outcome of each request of a transfer as the
+// streams finish them
+type requestTally struct {
+	mu    sync.Mutex
+	stats RequestStats
+}
+
+// record counts a request that returned err. A request is only cancelled if
+// the transfer itself was over; anything else that went wrong is a failure.
+func (t *requestTally) record(ctx context.Context, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case err == nil:
+		t.stats.Succeeded++
+	case ctx.Err() != nil:
+		t.stats.Cancelled++
+	default:
+		t.stats.Failed++
+		if t.stats.Errors == nil {
+			t.stats.Errors = make(map[string]int)
+		}
+		t.stats.Errors[errorClass(err)]++
+	}
+}
+
+// result returns a copy of the counts so far
+func (t *requestTally) result() RequestStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := t.stats
+	if t.stats.Errors != nil {
+		res.Errors = make(map[string]int, len(t.stats.Errors))
+		for k, v := range t.stats.Errors {
+			res.Errors[k] = v
+		}
+	}
+	return res
+}
+
+// statusError is a response whose status says the server did not serve the
+// test, such as a 404 from a wrong path or a 503 from a load balancer
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server returned HTTP %d %s", e.code, http.StatusText(e.code))
+}
+
+// checkStatus returns a statusError unless resp has a 2xx status
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &statusError{code: resp.StatusCode}
+	}
+	return nil
+}
+
+// errorClass names the cause of a failed request, coarse enough for the
+// counts to be read at a glance
+func errorClass(err error) string {
+	var se *statusError
+	var ne net.Error
+	switch {
+	case errors.As(err, &se):
+		return strconv.Itoa(se.code)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "reset"
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return "eof"
+	default:
+		return "error"
+	}
+}
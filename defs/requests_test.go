@@ -0,0 +1,84 @@
+package defs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestErrorClass(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"status", &statusError{code: http.StatusServiceUnavailable}, "503"},
+		{"wrapped status", fmt.Errorf("upload: %w", &statusError{code: 404}), "404"},
+		{"deadline", context.DeadlineExceeded, "timeout"},
+		{"refused", fmt.Errorf("dial: %w", syscall.ECONNREFUSED), "refused"},
+		{"reset", fmt.Errorf("read: %w", syscall.ECONNRESET), "reset"},
+		{"cut short", io.ErrUnexpectedEOF, "eof"},
+		{"anything else", errors.New("boom"), "error"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := errorClass(c.err); got != c.want {
+				t.Errorf("errorClass(%v) = %q, want %q", c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRequestTally(t *testing.T) {
+	var tally requestTally
+	live := context.Background()
+	over, cancel := context.WithCancel(live)
+	cancel()
+
+	tally.record(live, nil)
+	tally.record(live, nil)
+	tally.record(live, &statusError{code: 503})
+	tally.record(over, context.Canceled)
+
+	got := tally.result()
+	if got.Succeeded != 2 || got.Failed != 1 || got.Cancelled != 1 || got.Errors["503"] != 1 {
+		t.Errorf("result = %+v, want 2 succeeded, 1 failed with 503, 1 cancelled", got)
+	}
+	if r := got.ErrorRatio(); r != 1.0/3 {
+		t.Errorf("ErrorRatio = %v, want 1/3", r)
+	}
+	if s := got.errorSummary(); s != "503: 1" {
+		t.Errorf("errorSummary = %q, want %q", s, "503: 1")
+	}
+}
+
+// An error page must not count as throughput, however fast it is served.
+func TestDownloadIgnoresErrorPages(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(make([]byte, 1<<20))
+	}))
+	defer ts.Close()
+
+	s := &Server{Server: ts.URL, DownloadURL: "/"}
+	res, err := s.DownloadContext(context.Background(), TransferOptions{Silent: true, Requests: 1, Duration: 500 * time.Millisecond, MaxErrorRatio: 0.1, Chunks: 1})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.Bytes != 0 {
+		t.Errorf("counted %d byte(s) of error pages", res.Bytes)
+	}
+	if res.Requests.Failed == 0 || res.Requests.Errors["503"] != res.Requests.Failed {
+		t.Errorf("Requests = %+v, want the failures counted as 503", res.Requests)
+	}
+	if !res.TooManyErrors {
+		t.Error("TooManyErrors is not set with every request failing")
+	}
+}
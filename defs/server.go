@@ -6,22 +6,15 @@ import (
 	"crypto/rand"
 	"crypto/tls"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io"
 	"math"
 	"net"
 	"net/http"
-	"net/http/httptrace"
 	"net/url"
-	"os"
 	"path"
-	"slices"
 	"strconv"
-	"sync"
 	"time"
 
-	"github.com/briandowns/spinner"
 	"github.com/librespeed/speedtest-cli/output"
 	probing "github.com/prometheus-community/pro-bing"
 )
@@ -40,10 +33,28 @@ type Server struct {
 
 	NoICMP bool         `json:"-"`
 	TLog   TelemetryLog `json:"-"`
+	// Client makes every request to the server. Tests that bind different
+	// addresses or trust different CAs each need their own, so this is set
+	// per server rather than on http.DefaultClient. nil uses
+	// http.DefaultClient.
+	Client *http.Client `json:"-"`
+}
+
+// httpClient returns the client requests to the server are made with
+func (s *Server) httpClient() *http.Client {
+	if s.Client != nil {
+		return s.Client
+	}
+	return http.DefaultClient
 }
 
 // IsUp checks the speed test backend is up by accessing the ping URL
 func (s *Server) IsUp() bool {
+	return s.IsUpContext(context.Background())
+}
+
+// IsUpContext is IsUp, giving up as soon as ctx is cancelled
+func (s *Server) IsUpContext(ctx context.Context) bool {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("Check backend is up took %s", time.Since(t).String())
@@ -52,14 +63,14 @@ func (s *Server) IsUp() bool {
 	u, _ := s.GetURL()
 	u.Path = path.Join(u.Path, s.PingURL)
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		output.WriteDebug("Failed when creating HTTP request: %s\n", err)
 		return false
 	}
 	req.Header.Set("User-Agent", UserAgent)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.httpClient().Do(req)
 	if err != nil {
 		output.WriteDebug("Error checking for server status: %s\n", err)
 		return false
@@ -98,8 +109,52 @@ func rttMillis(d time.Duration) float64 {
 	return float64(d) / float64(time.Millisecond)
 }
 
+// JitterSmoothed is the jitter of smoothedJitter, the only method so far
+const JitterSmoothed = "smoothed"
+
+// The ways ping and packet loss can be measured
+const (
+	MethodICMP = "icmp"
+	MethodHTTP = "http"
+)
+
+// PingResult is what a ping test measured, in ms
+type PingResult struct {
+	Ping   float64
+	Jitter float64
+	// Method is MethodICMP, or MethodHTTP where ICMP was not used or not
+	// answered. Addr is the address the ICMP echoes went to.
+	Method string
+	Addr   string
+	// JitterMethod names how Jitter was worked out from Samples
+	JitterMethod string
+	// Samples are the round-trip times Ping and Jitter were worked out
+	// from, in the order they were measured
+	Samples []float64
+	// Timings break the requests of an HTTP ping down, and Conns describes
+	// the connections they used; both are nil over ICMP
+	Timings *ConnTimings
+	Conns   *ConnInfo
+}
+
+// Stats summarises the round-trip times
+func (r *PingResult) Stats() LatencyStats {
+	return NewLatencyStats(r.Samples)
+}
+
 // ICMPPingAndJitter pings the server via ICMP echos and calculate the average ping and jitter
 func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, float64, error) {
+	res, err := s.ICMPPing(context.Background(), count, srcIp, network)
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.Ping, res.Jitter, nil
+}
+
+// ICMPPing pings the server with count ICMP echoes, falling back to HTTP
+// pings where ICMP is not available. Cancelling ctx stops the pings, and
+// returns its error.
+func (s *Server) ICMPPing(ctx context.Context, count int, srcIp, network string) (*PingResult, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("ICMP ping took %s", time.Since(t).String())
@@ -107,20 +162,20 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 
 	if s.NoICMP {
 		output.WriteDebug("Skipping ICMP for server %s, will use HTTP ping\n", output.Sanitize(s.Name))
-		return s.PingAndJitter(count + 2)
+		return s.HTTPPing(ctx, count+2)
 	}
 
 	u, err := s.GetURL()
 	if err != nil {
 		output.WriteDebug("Failed to get server URL: %s\n", err)
-		return 0, 0, err
+		return nil, err
 	}
 
 	p, err := probing.NewPinger(u.Hostname())
 	if err != nil {
 		output.WriteDebug("Failed to resolve ping target: %s\n", err)
 		output.WriteDebug("Will try TCP ping\n")
-		return s.PingAndJitter(count + 2)
+		return s.HTTPPing(ctx, count+2)
 	}
 	p.SetNetwork(network)
 	p.Count = count
@@ -131,10 +186,13 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 	if output.IsDebug() {
 		p.Debug = true
 	}
-	if err := p.Run(); err != nil {
+	if err := p.RunWithContext(ctx); err != nil && ctx.Err() == nil {
 		output.WriteDebug("Failed to ping target host: %s\n", err)
 		output.WriteDebug("Will try TCP ping\n")
-		return s.PingAndJitter(count + 2)
+		return s.HTTPPing(ctx, count+2)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	stats := p.Statistics()
@@ -160,28 +218,21 @@ func (s *Server) ICMPPingAndJitter(count int, srcIp, network string) (float64, f
 		float64(stats.StdDevRtt.Microseconds())/1000,
 		stats.PacketsRecv, stats.PacketsSent)
 
-	var lastPing, jitter float64
-	for idx, rtt := range stats.Rtts {
-		if idx != 0 {
-			instJitter := math.Abs(lastPing - rttMillis(rtt))
-			if idx > 1 {
-				if jitter > instJitter {
-					jitter = jitter*0.7 + instJitter*0.3
-				} else {
-					jitter = instJitter*0.2 + jitter*0.8
-				}
-			}
-		}
-		lastPing = rttMillis(rtt)
-	}
-
 	if len(stats.Rtts) == 0 {
 		s.NoICMP = true
 		output.WriteDebug("No ICMP pings returned for server %s (%s), trying TCP ping\n", output.Sanitize(s.Name), output.Sanitize(u.Hostname()))
-		return s.PingAndJitter(count + 2)
+		return s.HTTPPing(ctx, count+2)
 	}
 
-	return rttMillis(stats.AvgRtt), jitter, nil
+	rtts := make([]float64, len(stats.Rtts))
+	for i, rtt := range stats.Rtts {
+		rtts[i] = rttMillis(rtt)
+	}
+	res := &PingResult{Ping: rttMillis(stats.AvgRtt), Jitter: smoothedJitter(rtts), JitterMethod: JitterSmoothed, Samples: rtts, Method: MethodICMP}
+	if stats.IPAddr != nil {
+		res.Addr = stats.IPAddr.String()
+	}
+	return res, nil
 }
 
 // addressFamily names the IP version of an address, for reporting which path a
@@ -204,6 +255,17 @@ func addressFamily(addr string) string {
 
 // PingAndJitter pings the server via accessing ping URL and calculate the average ping and jitter
 func (s *Server) PingAndJitter(count int) (float64, float64, error) {
+	res, err := s.HTTPPing(context.Background(), count)
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.Ping, res.Jitter, nil
+}
+
+// HTTPPing pings the server with count requests to its ping URL. The first
+// one, which sets the connection up, is left out of the ping and jitter but
+// not out of the timings. Cancelling ctx ends the pings with its error.
+func (s *Server) HTTPPing(ctx context.Context, count int) (*PingResult, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("TCP ping took %s", time.Since(t).String())
@@ -212,7 +274,7 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 	u, err := s.GetURL()
 	if err != nil {
 		output.WriteDebug("Failed to get server URL: %s\n", err)
-		return 0, 0, err
+		return nil, err
 	}
 	u.Path = path.Join(u.Path, s.PingURL)
 
@@ -221,33 +283,17 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
 		output.WriteDebug("Failed when creating HTTP request: %s\n", err)
-		return 0, 0, err
+		return nil, err
 	}
 	req.Header.Set("User-Agent", UserAgent)
 
-	// Collect every distinct peer, not just the first. The requests usually
-	// share one connection, but a reconnect can land on a different address --
-	// a different family, even -- and reporting only the first would then
-	// describe a connection the later samples did not use.
-	var remotes []string
-	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
-		GotConn: func(info httptrace.GotConnInfo) {
-			if info.Conn == nil {
-				return
-			}
-			addr := info.Conn.RemoteAddr().String()
-			if !slices.Contains(remotes, addr) {
-				remotes = append(remotes, addr)
-			}
-		},
-	}))
-
+	var tracer connTracer
 	for i := 0; i < count; i++ {
 		start := time.Now()
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := s.httpClient().Do(req.WithContext(tracer.trace(ctx)))
 		if err != nil {
 			output.WriteDebug("Failed when making HTTP request: %s\n", err)
-			return 0, 0, err
+			return nil, err
 		}
 		io.Copy(io.Discard, resp.Body)
 		resp.Body.Close()
@@ -256,7 +302,8 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 		pings = append(pings, rttMillis(end.Sub(start)))
 	}
 
-	for _, addr := range remotes {
+	conns := tracer.conns()
+	for _, addr := range conns.Remotes {
 		output.WriteDebug("Pinging %s over TCP (%s)\n", addr, addressFamily(addr))
 	}
 
@@ -265,8 +312,23 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 		pings = pings[1:]
 	}
 
+	return &PingResult{
+		Ping:         getAvg(pings),
+		Jitter:       smoothedJitter(pings),
+		JitterMethod: JitterSmoothed,
+		Samples:      pings,
+		Method:       MethodHTTP,
+		Timings:      tracer.result(),
+		Conns:        conns,
+	}, nil
+}
+
+// smoothedJitter works jitter out the way the LibreSpeed web client does: a
+// moving average of the differences between consecutive round-trip times,
+// quicker to rise than to fall. The first difference only seeds it.
+func smoothedJitter(rtts []float64) float64 {
 	var lastPing, jitter float64
-	for idx, p := range pings {
+	for idx, p := range rtts {
 		if idx != 0 {
 			instJitter := math.Abs(lastPing - p)
 			if idx > 1 {
@@ -279,178 +341,116 @@ func (s *Server) PingAndJitter(count int) (float64, float64, error) {
 		}
 		lastPing = p
 	}
-
-	return getAvg(pings), jitter, nil
+	return jitter
 }
 
-// Download performs the actual download test
-// streamProgress emits one progress event a second while a transfer phase
-// runs, reading the rate off the phase's byte counter. The returned stop
-// function ends the ticker and does not return until the goroutine is done,
-// so a late progress event can never land after the next phase event.
-func streamProgress(phase string, counter *BytesCounter, duration time.Duration) func() {
-	if !output.StreamEnabled() {
-		return func() {}
-	}
-
-	done := make(chan struct{})
-	stopped := make(chan struct{})
-	start := time.Now()
-
-	go func() {
-		defer close(stopped)
-		ticker := time.NewTicker(time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				elapsed := time.Since(start).Seconds()
-				output.WriteEvent(output.ProgressEvent{
-					Event:    "progress",
-					Phase:    phase,
-					Seconds:  math.Round(elapsed*10) / 10,
-					Mbps:     math.Round(counter.AvgMbps()*100) / 100,
-					Progress: int(math.Min(elapsed/duration.Seconds()*100, 100)),
-				})
-			}
-		}
-	}()
-
-	return func() {
-		close(done)
-		<-stopped
+// Download runs a download test with `requests` requests of `chunks` chunks
+// for `duration`, and returns its rate in Mbps and the bytes it received.
+//
+// Deprecated: use DownloadContext, which can be interrupted and reports more
+// than the rate.
+func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chunks int, duration time.Duration) (float64, uint64, error) {
+	res, err := s.DownloadContext(context.Background(), TransferOptions{
+		Silent:   silent,
+		UseBytes: useBytes,
+		UseMebi:  useMebi,
+		Requests: requests,
+		Chunks:   chunks,
+		Duration: duration,
+	})
+	if err != nil {
+		return 0, 0, err
 	}
+	return res.Mbps, res.Bytes, nil
 }
 
-func (s *Server) Download(silent bool, useBytes, useMebi bool, requests int, chunks int, duration time.Duration) (float64, uint64, error) {
+// DownloadContext performs the actual download test. Cancelling ctx cuts it
+// short, with the result measured so far.
+func (s *Server) DownloadContext(ctx context.Context, opts TransferOptions) (*TransferResult, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("Download took %s", time.Since(t).String())
 	}()
 
 	counter := NewCounter()
-	counter.SetMebi(useMebi)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	counter.SetMebi(opts.UseMebi)
 
 	u, err := s.GetURL()
 	if err != nil {
 		output.WriteDebug("Failed to get server URL: %s\n", err)
-		return 0, 0, err
+		return nil, err
 	}
 
 	u.Path = path.Join(u.Path, s.DownloadURL)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
 		output.WriteDebug("Failed when creating HTTP request: %s\n", err)
-		return 0, 0, err
+		return nil, err
 	}
 	q := req.URL.Query()
-	q.Set("ckSize", strconv.Itoa(chunks))
+	q.Set("ckSize", strconv.Itoa(opts.Chunks))
 	req.URL.RawQuery = q.Encode()
 	req.Header.Set("User-Agent", UserAgent)
 	req.Header.Set("Accept-Encoding", "identity")
 
-	downloadDone := make(chan struct{}, requests)
-
-	var wg sync.WaitGroup
-
-	doDownload := func() {
-		defer wg.Done()
-
-		reqClone := req.Clone(ctx)
-		resp, err := http.DefaultClient.Do(reqClone)
+	var tracer connTracer
+	doDownload := func(ctx context.Context) error {
+		reqClone := req.Clone(tracer.trace(ctx))
+		resp, err := s.httpClient().Do(reqClone)
 		if err != nil {
-			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
-				output.WriteDebug("Failed when making HTTP request: %s\n", err)
-			}
-			return
+			return err
 		}
 		defer resp.Body.Close()
 
-		if _, err = io.Copy(io.Discard, io.TeeReader(resp.Body, counter)); err != nil {
-			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
-				output.WriteDebug("Failed when reading HTTP response: %s\n", err)
-			}
+		// an error page, or a captive portal's login page, is not throughput
+		if err := checkStatus(resp); err != nil {
+			return err
 		}
-
-		// let the main loop start a replacement request, but never block on it
-		// once the test is over, otherwise this goroutine is leaked
-		select {
-		case downloadDone <- struct{}{}:
-		case <-ctx.Done():
-		}
-	}
-
-	spawnDownload := func() {
-		wg.Add(1)
-		go doDownload()
+		_, err = io.Copy(io.Discard, io.TeeReader(resp.Body, counter))
+		return err
 	}
 
-	counter.Start()
-	defer streamProgress("download", counter, duration)()
-	if !silent {
-		pb := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriterFile(os.Stderr))
-		pb.Prefix = "Downloading...  "
-		pb.PostUpdate = func(s *spinner.Spinner) {
-			if useBytes {
-				s.Suffix = fmt.Sprintf("  %s", counter.AvgHumanize())
-			} else {
-				s.Suffix = fmt.Sprintf("  %.2f Mbps", counter.AvgMbps())
-			}
-		}
+	stopTCP := tracer.sampleTCP(sampleInterval)
+	res := s.transfer(ctx, "download", counter, opts, doDownload)
+	stopTCP()
+	res.Timings, res.Conns, res.TCP = tracer.result(), tracer.conns(), tracer.tcpStats()
+	return res, nil
+}
 
-		pb.Start()
-		// print the rate ourselves instead of via pb.FinalMSG: the spinner only
-		// prints it when it was actually running, which it isn't when stderr is
-		// not a terminal
-		defer func() {
-			pb.Stop()
-			if useBytes {
-				output.WriteUI("Download rate:\t%s\n", counter.AvgHumanize())
-			} else {
-				output.WriteUI("Download rate:\t%.2f Mbps\n", counter.AvgMbps())
-			}
-		}()
-	}
-
-	for i := 0; i < requests; i++ {
-		spawnDownload()
-		time.Sleep(200 * time.Millisecond)
-	}
-	timeout := time.After(duration)
-Loop:
-	for {
-		select {
-		case <-timeout:
-			cancel()
-			break Loop
-		case <-downloadDone:
-			spawnDownload()
-		}
+// Upload runs an upload test with `requests` requests of `uploadSize` KiB for
+// `duration`, and returns its rate in Mbps and the bytes it sent.
+//
+// Deprecated: use UploadContext, which can be interrupted and reports more
+// than the rate.
+func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int, uploadSize int, duration time.Duration) (float64, uint64, error) {
+	res, err := s.UploadContext(context.Background(), TransferOptions{
+		Silent:        silent,
+		UseBytes:      useBytes,
+		UseMebi:       useMebi,
+		Requests:      requests,
+		UploadSize:    uploadSize,
+		NoPreAllocate: noPrealloc,
+		Duration:      duration,
+	})
+	if err != nil {
+		return 0, 0, err
 	}
-
-	// let the cancelled requests unwind before reading the counter, so the
-	// result doesn't change under us while it's being reported
-	wg.Wait()
-
-	return counter.AvgMbps(), counter.Total(), nil
+	return res.Mbps, res.Bytes, nil
 }
 
-// Upload performs the actual upload test
-func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int, uploadSize int, duration time.Duration) (float64, uint64, error) {
+// UploadContext performs the actual upload test. Cancelling ctx cuts it
+// short, with the result measured so far.
+func (s *Server) UploadContext(ctx context.Context, opts TransferOptions) (*TransferResult, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("Upload took %s", time.Since(t).String())
 	}()
 
 	counter := NewCounter()
-	counter.SetMebi(useMebi)
-	counter.SetUploadSize(uploadSize)
+	counter.SetMebi(opts.UseMebi)
+	counter.SetUploadSize(opts.UploadSize)
 
+	noPrealloc := opts.NoPreAllocate
 	if noPrealloc {
 		output.WriteUI("Pre-allocation is disabled, performance might be lower!\n")
 	} else {
@@ -462,32 +462,26 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int
 	u, err := s.GetURL()
 	if err != nil {
 		output.WriteDebug("Failed to get server URL: %s\n", err)
-		return 0, 0, err
+		return nil, err
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 	u.Path = path.Join(u.Path, s.UploadURL)
 
-	uploadDone := make(chan struct{}, requests)
-
-	var wg sync.WaitGroup
-
-	doUpload := func() {
-		defer wg.Done()
-
+	var tracer connTracer
+	doUpload := func(ctx context.Context) error {
 		var bodyReader io.Reader
 		if noPrealloc {
 			bodyReader = &SeekWrapper{rand.Reader}
 		} else {
 			bodyReader = bytes.NewReader(counter.Payload())
 		}
-		countingReader := io.TeeReader(bodyReader, counter)
+		// what this request sent is also counted on its own, to be taken
+		// back should the server reject it
+		sent := NewCounter()
+		countingReader := io.TeeReader(bodyReader, io.MultiWriter(counter, sent))
 
-		uploadReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), countingReader)
+		uploadReq, err := http.NewRequestWithContext(tracer.trace(ctx), http.MethodPost, u.String(), countingReader)
 		if err != nil {
-			output.WriteDebug("Failed when creating HTTP request: %s\n", err)
-			return
+			return err
 		}
 		uploadReq.Header.Set("User-Agent", UserAgent)
 		uploadReq.Header.Set("Accept-Encoding", "identity")
@@ -506,87 +500,36 @@ func (s *Server) Upload(noPrealloc, silent, useBytes, useMebi bool, requests int
 			uploadReq.ContentLength = int64(len(counter.Payload()))
 		}
 
-		resp, err := http.DefaultClient.Do(uploadReq)
+		resp, err := s.httpClient().Do(uploadReq)
 		if err != nil {
-			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
-				output.WriteDebug("Failed when making HTTP request: %s\n", err)
-			}
-			return
+			return err
 		}
 		defer resp.Body.Close()
 
-		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-			// cancellation is how the test ends, so it is not a failure
-			if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
-				output.WriteDebug("Failed when reading HTTP response: %s\n", err)
-			}
+		// the payload was counted as it was sent, but a server that rejected
+		// it did not take it, as an error page is not throughput downloaded
+		if err := checkStatus(resp); err != nil {
+			counter.Uncount(sent.Total())
+			return err
 		}
-
-		// let the main loop start a replacement request, but never block on it
-		// once the test is over, otherwise this goroutine is leaked
-		select {
-		case uploadDone <- struct{}{}:
-		case <-ctx.Done():
-		}
-	}
-
-	spawnUpload := func() {
-		wg.Add(1)
-		go doUpload()
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
 	}
 
-	counter.Start()
-	defer streamProgress("upload", counter, duration)()
-	if !silent {
-		pb := spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriterFile(os.Stderr))
-		pb.Prefix = "Uploading...  "
-		pb.PostUpdate = func(s *spinner.Spinner) {
-			if useBytes {
-				s.Suffix = fmt.Sprintf("  %s", counter.AvgHumanize())
-			} else {
-				s.Suffix = fmt.Sprintf("  %.2f Mbps", counter.AvgMbps())
-			}
-		}
-
-		pb.Start()
-		// print the rate ourselves instead of via pb.FinalMSG: the spinner only
-		// prints it when it was actually running, which it isn't when stderr is
-		// not a terminal
-		defer func() {
-			pb.Stop()
-			if useBytes {
-				output.WriteUI("Upload rate:\t%s\n", counter.AvgHumanize())
-			} else {
-				output.WriteUI("Upload rate:\t%.2f Mbps\n", counter.AvgMbps())
-			}
-		}()
-	}
-
-	for i := 0; i < requests; i++ {
-		spawnUpload()
-		time.Sleep(200 * time.Millisecond)
-	}
-	timeout := time.After(duration)
-Loop:
-	for {
-		select {
-		case <-timeout:
-			cancel()
-			break Loop
-		case <-uploadDone:
-			spawnUpload()
-		}
-	}
-
-	// let the cancelled requests unwind before reading the counter, so the
-	// result doesn't change under us while it's being reported
-	wg.Wait()
-
-	return counter.AvgMbps(), counter.Total(), nil
+	stopTCP := tracer.sampleTCP(sampleInterval)
+	res := s.transfer(ctx, "upload", counter, opts, doUpload)
+	stopTCP()
+	res.Timings, res.Conns, res.TCP = tracer.result(), tracer.conns(), tracer.tcpStats()
+	return res, nil
 }
 
 // GetIPInfo accesses the backend's getIP.php endpoint and get current client's IP information
 func (s *Server) GetIPInfo(distanceUnit string) (*GetIPResult, error) {
+	return s.GetIPInfoContext(context.Background(), distanceUnit)
+}
+
+// GetIPInfoContext is GetIPInfo, giving up as soon as ctx is cancelled
+func (s *Server) GetIPInfoContext(ctx context.Context, distanceUnit string) (*GetIPResult, error) {
 	t := time.Now()
 	defer func() {
 		s.TLog.Logf("Get IP info took %s", time.Since(t).String())
@@ -604,14 +547,14 @@ func (s *Server) GetIPInfo(distanceUnit string) (*GetIPResult, error) {
 	q.Set("distance", distanceUnit)
 	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
 	if err != nil {
 		output.WriteDebug("Failed when creating HTTP request: %s\n", err)
 		return nil, err
 	}
 	req.Header.Set("User-Agent", UserAgent)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := s.httpClient().Do(req)
 	if err != nil {
 		output.WriteDebug("Failed when making HTTP request: %s\n", err)
 		return nil, err
@@ -2,6 +2,7 @@ package defs
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"io"
 	"net"
@@ -199,10 +200,16 @@ func TestUploadSendsContentLength(t *testing.T) {
 		requests      = 2
 	)
 
-	_, total, err := s.Upload(false, true, false, false, requests, uploadSizeKiB, duration)
+	res, err := s.UploadContext(context.Background(), TransferOptions{
+		Silent:     true,
+		Requests:   requests,
+		Duration:   duration,
+		UploadSize: uploadSizeKiB,
+	})
 	if err != nil {
 		t.Fatalf("Upload returned error: %v", err)
 	}
+	total := res.Bytes
 
 	if got := contentLength.Load(); got != payloadBytes {
 		t.Errorf("server saw Content-Length %d, want %d (chunked encoding or a wrong length stalls the upload)", got, int64(payloadBytes))
@@ -214,3 +221,49 @@ func TestUploadSendsContentLength(t *testing.T) {
 		t.Errorf("counter reported %d bytes, want more than one payload", total)
 	}
 }
+
+// roundTripFunc lets a test stand in for a transport.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// Requests must go through the server's own client, so two tests configured
+// differently can run in one process; http.DefaultClient is not involved.
+func TestServerUsesItsOwnClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	var used atomic.Int64
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		used.Add(1)
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+
+	s := &Server{Server: ts.URL, PingURL: "/", Client: client}
+	if !s.IsUp() {
+		t.Fatal("IsUp() = false, want true")
+	}
+	if _, _, err := s.PingAndJitter(3); err != nil {
+		t.Fatalf("PingAndJitter returned error: %v", err)
+	}
+
+	if got := used.Load(); got != 4 {
+		t.Errorf("server's client made %d requests, want 4", got)
+	}
+}
+
+// The signatures from before TransferOptions still work for callers of the
+// package
+func TestDeprecatedTransfers(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+	s.UploadURL = "/"
+
+	mbps, n, err := s.Download(true, false, false, 1, 1, 300*time.Millisecond)
+	if err != nil || mbps <= 0 || n == 0 {
+		t.Errorf("Download = %.2f Mbps, %d byte(s), %v, want a rate", mbps, n, err)
+	}
+	mbps, n, err = s.Upload(false, true, false, false, 1, 64, 300*time.Millisecond)
+	if err != nil || mbps <= 0 || n == 0 {
+		t.Errorf("Upload = %.2f Mbps, %d byte(s), %v, want a rate", mbps, n, err)
+	}
+}
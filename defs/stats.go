@@ -0,0 +1,91 @@
+package defs
+
+import (
+	"math"
+	"slices"
+)
+
+// ThroughputStats summarises the rate samples of a transfer phase
+type ThroughputStats struct {
+	Min    float64
+	Median float64
+	P90    float64
+	Max    float64
+	// CV is the coefficient of variation: the standard deviation as a
+	// fraction of the mean. A steady link stays close to zero whatever its
+	// speed, which is what makes runs over different links comparable.
+	CV float64
+}
+
+// NewThroughputStats works out the statistics of a series of rate samples.
+// No samples gives all zeroes.
+func NewThroughputStats(samples []float64) ThroughputStats {
+	if len(samples) == 0 {
+		return ThroughputStats{}
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	stats := ThroughputStats{
+		Min:    sorted[0],
+		Median: percentile(sorted, 50),
+		P90:    percentile(sorted, 90),
+		Max:    sorted[len(sorted)-1],
+	}
+	if mean := getAvg(samples); mean > 0 {
+		stats.CV = stdDev(samples, mean) / mean
+	}
+	return stats
+}
+
+// LatencyStats summarises round-trip times, in ms. The spread matters as much
+// as the average: a few spikes on an otherwise quick link are what breaks a
+// call or a game, and the mean all but hides them.
+type LatencyStats struct {
+	Min    float64
+	Median float64
+	P95    float64
+	Max    float64
+	StdDev float64
+}
+
+// NewLatencyStats works out the statistics of a series of round-trip times.
+// No samples gives all zeroes.
+func NewLatencyStats(rtts []float64) LatencyStats {
+	if len(rtts) == 0 {
+		return LatencyStats{}
+	}
+
+	sorted := slices.Clone(rtts)
+	slices.Sort(sorted)
+
+	return LatencyStats{
+		Min:    sorted[0],
+		Median: percentile(sorted, 50),
+		P95:    percentile(sorted, 95),
+		Max:    sorted[len(sorted)-1],
+		StdDev: stdDev(rtts, getAvg(rtts)),
+	}
+}
+
+// percentile returns the p-th percentile of sorted values, interpolating
+// linearly between the two nearest ranks
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// stdDev returns the population standard deviation of vals around mean
+func stdDev(vals []float64, mean float64) float64 {
+	var sum float64
+	for _, v := range vals {
+		sum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sum / float64(len(vals)))
+}
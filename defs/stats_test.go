@@ -0,0 +1,76 @@
+package defs
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewThroughputStats(t *testing.T) {
+	// a link that bursts and then throttles: the average alone reads 55
+	samples := []float64{100, 100, 100, 100, 100, 10, 10, 10, 10, 10}
+
+	got := NewThroughputStats(samples)
+	want := ThroughputStats{Min: 10, Median: 55, P90: 100, Max: 100, CV: 45.0 / 55.0}
+
+	if got.Min != want.Min || got.Median != want.Median || got.P90 != want.P90 || got.Max != want.Max {
+		t.Errorf("NewThroughputStats = %+v, want %+v", got, want)
+	}
+	if math.Abs(got.CV-want.CV) > 1e-9 {
+		t.Errorf("CV = %v, want %v", got.CV, want.CV)
+	}
+}
+
+func TestNewThroughputStatsSteadyLink(t *testing.T) {
+	got := NewThroughputStats([]float64{50, 50, 50})
+	if got.CV != 0 {
+		t.Errorf("CV of a steady link = %v, want 0", got.CV)
+	}
+}
+
+func TestNewThroughputStatsNoSamples(t *testing.T) {
+	if got := NewThroughputStats(nil); got != (ThroughputStats{}) {
+		t.Errorf("NewThroughputStats(nil) = %+v, want zeroes", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	cases := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{50, 3},
+		{90, 4.6},
+		{100, 5},
+	}
+
+	for _, c := range cases {
+		if got := percentile(sorted, c.p); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("percentile(%v) = %v, want %v", c.p, got, c.want)
+		}
+	}
+	if got := percentile([]float64{7}, 90); got != 7 {
+		t.Errorf("percentile of one value = %v, want 7", got)
+	}
+}
+
+func TestNewLatencyStats(t *testing.T) {
+	// a quick link with one spike: the mean reads 49, the median 10
+	rtts := []float64{10, 11, 9, 10, 400, 10, 10, 11, 9, 10}
+
+	got := NewLatencyStats(rtts)
+	if got.Min != 9 || got.Median != 10 || got.Max != 400 {
+		t.Errorf("NewLatencyStats = %+v, want min 9, median 10, max 400", got)
+	}
+	// the 95th percentile lies between the two highest samples
+	if got.P95 <= 11 || got.P95 >= 400 {
+		t.Errorf("P95 = %v, want it between 11 and 400", got.P95)
+	}
+	if math.Abs(got.StdDev-117) > 1 {
+		t.Errorf("StdDev = %v, want about 117", got.StdDev)
+	}
+	if got := NewLatencyStats(nil); got != (LatencyStats{}) {
+		t.Errorf("NewLatencyStats(nil) = %+v, want zeroes", got)
+	}
+}
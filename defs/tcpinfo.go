@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package defs
+
+import (
+	"fmt"
+	"net"
+)
+
+func readTCPInfo(conn net.Conn) (*TCPStats, error) {
+	return nil, fmt.Errorf("cannot read TCP_INFO on this platform")
+}
@@ -0,0 +1,50 @@
+package defs
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// readTCPInfo reads the TCP_INFO of conn's socket, through the same
+// syscall.RawConn control path the dialer binds sockets with
+func readTCPInfo(conn net.Conn) (*TCPStats, error) {
+	if tc, ok := conn.(*tls.Conn); ok {
+		conn = tc.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("not a socket")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var info *unix.TCPInfo
+	var errSock error
+	err = raw.Control(func(fd uintptr) {
+		info, errSock = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errSock != nil {
+		return nil, errSock
+	}
+
+	// older kernels leave the fields they do not know at zero
+	return &TCPStats{
+		Local:        conn.LocalAddr().String(),
+		Retransmits:  int(info.Total_retrans),
+		SegmentsOut:  int(info.Segs_out),
+		OutOfOrder:   int(info.Rcv_ooopack),
+		RTT:          float64(info.Rtt) / 1000,
+		Cwnd:         int(info.Snd_cwnd),
+		DeliveryRate: float64(info.Delivery_rate) * 8 / 1e6,
+		RcvSpace:     int(info.Rcv_space),
+	}, nil
+}
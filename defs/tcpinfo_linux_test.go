@@ -0,0 +1,33 @@
+package defs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Every connection the download used is sampled, with counts covering the
+// download alone
+func TestDownloadSamplesTCPInfo(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<20))
+	}))
+	defer ts.Close()
+
+	s := &Server{Server: ts.URL, DownloadURL: "/"}
+	res, err := s.DownloadContext(context.Background(), TransferOptions{Silent: true, Requests: 2, Duration: 500 * time.Millisecond, Chunks: 1})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if len(res.TCP) == 0 || len(res.TCP) > res.Timings.NewConns {
+		t.Fatalf("sampled %d connection(s), want one for each of the %d opened", len(res.TCP), res.Timings.NewConns)
+	}
+	for _, stats := range res.TCP {
+		// the client only sends requests and ACKs, but it does send them
+		if stats.Local == "" || stats.SegmentsOut <= 0 || stats.RTT <= 0 || stats.RcvSpace <= 0 {
+			t.Errorf("TCP stats = %+v, want a socket with segments sent, an RTT and a receive window", stats)
+		}
+	}
+}
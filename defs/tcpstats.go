@@ -0,0 +1,136 @@
+package defs
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+// TCPStats is what the kernel reports through TCP_INFO about a connection a
+// download or upload used. Together with the rate, it tells loss on the path
+// apart from a window too small for the path's bandwidth-delay product.
+type TCPStats struct {
+	// Local is the connection's local address, which tells the streams apart
+	Local string
+	// Retransmits counts the segments sent again, out of SegmentsOut sent,
+	// and OutOfOrder the packets received out of order; all three only count
+	// what happened during the phase. Loss on the path shows in the first
+	// during an upload, and in the last during a download.
+	Retransmits int
+	SegmentsOut int
+	OutOfOrder  int
+	// RTT is the kernel's smoothed round-trip time in ms, Cwnd the congestion
+	// window in segments, and DeliveryRate the most recent rate the peer
+	// acknowledged data at, in Mbps. They describe the sending side, so the
+	// upload.
+	RTT          float64
+	Cwnd         int
+	DeliveryRate float64
+	// RcvSpace is the receive window the kernel has grown to, in bytes. A
+	// download that moves about RcvSpace per RTT is held back by the client.
+	RcvSpace int
+}
+
+// AggregateTCPStats adds up the stats of a phase's connections: the counts,
+// windows and delivery rates are summed, and the RTT is the median of theirs.
+// No connections gives all zeroes.
+func AggregateTCPStats(streams []TCPStats) TCPStats {
+	var total TCPStats
+	rtts := make([]float64, 0, len(streams))
+	for _, s := range streams {
+		total.Retransmits += s.Retransmits
+		total.SegmentsOut += s.SegmentsOut
+		total.OutOfOrder += s.OutOfOrder
+		total.Cwnd += s.Cwnd
+		total.DeliveryRate += s.DeliveryRate
+		total.RcvSpace += s.RcvSpace
+		rtts = append(rtts, s.RTT)
+	}
+	slices.Sort(rtts)
+	total.RTT = percentile(rtts, 50)
+	return total
+}
+
+// tcpSamples are the first and the latest TCP_INFO sample of a connection
+type tcpSamples struct {
+	first, last TCPStats
+}
+
+// sampleTCP starts sampling the TCP_INFO of every connection c sees, each
+// time a request gets one and every interval, until the returned function is
+// called; that takes a last sample of the connections still open. Where
+// TCP_INFO cannot be read, nothing is sampled.
+func (c *connTracer) sampleTCP(interval time.Duration) (stop func()) {
+	c.mu.Lock()
+	c.tcp = make(map[net.Conn]*tcpSamples)
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				c.sampleConns()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		c.sampleConns()
+	}
+}
+
+// sampleConns samples every connection seen so far. Closed connections
+// cannot be read any more, and keep their last sample.
+func (c *connTracer) sampleConns() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, conn := range c.tcpConns {
+		c.sampleConnLocked(conn)
+	}
+}
+
+// sampleConnLocked samples conn, which the caller has seen, with c.mu held
+func (c *connTracer) sampleConnLocked(conn net.Conn) {
+	if c.tcp == nil {
+		return
+	}
+	stats, err := readTCPInfo(conn)
+	if err != nil {
+		return
+	}
+	if s, ok := c.tcp[conn]; ok {
+		s.last = *stats
+		return
+	}
+	c.tcp[conn] = &tcpSamples{first: *stats, last: *stats}
+	c.tcpConns = append(c.tcpConns, conn)
+}
+
+// tcpStats returns the stats of every connection sampled, in the order they
+// were first seen. The counts are those since the first sample, as a
+// connection may come from the pool with an earlier phase's on it.
+func (c *connTracer) tcpStats() []TCPStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var streams []TCPStats
+	for _, conn := range c.tcpConns {
+		s := c.tcp[conn]
+		stats := s.last
+		stats.Retransmits -= s.first.Retransmits
+		stats.SegmentsOut -= s.first.SegmentsOut
+		stats.OutOfOrder -= s.first.OutOfOrder
+		streams = append(streams, stats)
+	}
+	return streams
+}
@@ -0,0 +1,22 @@
+package defs
+
+import (
+	"testing"
+)
+
+func TestAggregateTCPStats(t *testing.T) {
+	streams := []TCPStats{
+		{Retransmits: 2, SegmentsOut: 100, OutOfOrder: 1, RTT: 10, Cwnd: 20, DeliveryRate: 50, RcvSpace: 1000},
+		{Retransmits: 0, SegmentsOut: 300, RTT: 30, Cwnd: 10, DeliveryRate: 25, RcvSpace: 3000},
+		{Retransmits: 1, SegmentsOut: 100, RTT: 12, Cwnd: 10, DeliveryRate: 25, RcvSpace: 2000},
+	}
+
+	got := AggregateTCPStats(streams)
+	want := TCPStats{Retransmits: 3, SegmentsOut: 500, OutOfOrder: 1, RTT: 12, Cwnd: 40, DeliveryRate: 100, RcvSpace: 6000}
+	if got != want {
+		t.Errorf("AggregateTCPStats = %+v, want %+v", got, want)
+	}
+	if got := AggregateTCPStats(nil); got != (TCPStats{}) {
+		t.Errorf("AggregateTCPStats of no streams = %+v, want zeroes", got)
+	}
+}
@@ -0,0 +1,235 @@
+package defs
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ConnTimings breaks the HTTP requests of a test phase down into where their
+// time went, in ms. DNS, Connect and TLS are only recorded for the requests
+// that opened a connection, and only for the steps they took: an address
+// needs no lookup, and plain HTTP no handshake. TTFB runs from the request
+// having been written to the first byte of the response, so it leaves out
+// both the setup and an upload's body.
+type ConnTimings struct {
+	// Requests counts the requests that got a response, and NewConns those of
+	// them that could not reuse a connection
+	Requests int
+	NewConns int
+	DNS      []float64
+	Connect  []float64
+	TLS      []float64
+	TTFB     []float64
+}
+
+// ConnInfo describes the connections a test phase's requests used
+type ConnInfo struct {
+	// Remotes are the distinct addresses connected to, in the order they
+	// were first used. The requests usually share one or a few connections,
+	// but a reconnect can land on another address, of another family even.
+	Remotes []string
+	// TLSVersion and TLSCipher are what the first TLS connection negotiated,
+	// empty over plain HTTP
+	TLSVersion string
+	TLSCipher  string
+}
+
+// Family names the IP version of the addresses connected to: "IPv4", "IPv6",
+// "mixed" when both were used, or "" when there were none
+func (i *ConnInfo) Family() string {
+	var family string
+	for _, addr := range i.Remotes {
+		switch f := addressFamily(addr); {
+		case family == "":
+			family = f
+		case f != family:
+			return "mixed"
+		}
+	}
+	return family
+}
+
+// MergeConnInfo describes the connections of several phases together,
+// skipping nil ones
+func MergeConnInfo(infos ...*ConnInfo) *ConnInfo {
+	var all ConnInfo
+	for _, i := range infos {
+		if i == nil {
+			continue
+		}
+		for _, addr := range i.Remotes {
+			if !slices.Contains(all.Remotes, addr) {
+				all.Remotes = append(all.Remotes, addr)
+			}
+		}
+		if all.TLSVersion == "" {
+			all.TLSVersion, all.TLSCipher = i.TLSVersion, i.TLSCipher
+		}
+	}
+	return &all
+}
+
+// connTracer collects the ConnTimings and ConnInfo of the requests it
+// traces, which may run concurrently
+type connTracer struct {
+	mu      sync.Mutex
+	timings ConnTimings
+	info    ConnInfo
+	// tcp holds the TCP_INFO samples of each connection, in the order of
+	// tcpConns, once sampleTCP has been called
+	tcp      map[net.Conn]*tcpSamples
+	tcpConns []net.Conn
+}
+
+// reqTrace holds the timestamps of one request. The connection's hooks can
+// run on the goroutine dialing it rather than the request's.
+type reqTrace struct {
+	mu                                   sync.Mutex
+	dnsStart, connStart, tlsStart, wrote time.Time
+	dns, connect, handshake              float64
+	reused, gotConn                      bool
+}
+
+// trace returns ctx with every request made with it traced into c
+func (c *connTracer) trace(ctx context.Context) context.Context {
+	r := &reqTrace{}
+	since := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return rttMillis(time.Since(t))
+	}
+	locked := func(f func()) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		f()
+	}
+
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			locked(func() { r.dnsStart = time.Now() })
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			locked(func() {
+				if info.Err == nil {
+					r.dns = since(r.dnsStart)
+				}
+			})
+		},
+		// with several addresses more than one dial can be tried; the
+		// time to the one that connected is what the request waited
+		ConnectStart: func(string, string) {
+			locked(func() {
+				if r.connStart.IsZero() {
+					r.connStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			locked(func() {
+				if err == nil {
+					r.connect = since(r.connStart)
+				}
+			})
+		},
+		TLSHandshakeStart: func() {
+			locked(func() { r.tlsStart = time.Now() })
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			locked(func() {
+				if err == nil {
+					r.handshake = since(r.tlsStart)
+				}
+			})
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			locked(func() { r.reused, r.gotConn = info.Reused, true })
+			c.sawConn(info.Conn)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			locked(func() { r.wrote = time.Now() })
+		},
+		GotFirstResponseByte: func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			c.record(r, time.Now())
+		},
+	})
+}
+
+// record adds the timings of a request whose response started at first
+func (c *connTracer) record(r *reqTrace, first time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &c.timings
+	t.Requests++
+	// a server may answer before it has read the whole request, as one
+	// rejecting an upload does, which leaves no time to first byte
+	if !r.wrote.IsZero() {
+		t.TTFB = append(t.TTFB, rttMillis(first.Sub(r.wrote)))
+	}
+	// a request that got an idle connection may still see the dial it
+	// started finish, for some later request to use
+	if !r.gotConn || r.reused {
+		return
+	}
+	t.NewConns++
+	if r.dns > 0 {
+		t.DNS = append(t.DNS, r.dns)
+	}
+	if r.connect > 0 {
+		t.Connect = append(t.Connect, r.connect)
+	}
+	if r.handshake > 0 {
+		t.TLS = append(t.TLS, r.handshake)
+	}
+}
+
+// sawConn adds a connection a request got to the ConnInfo
+func (c *connTracer) sawConn(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if addr := conn.RemoteAddr().String(); !slices.Contains(c.info.Remotes, addr) {
+		c.info.Remotes = append(c.info.Remotes, addr)
+	}
+	// the handshake is over by the time a request gets its connection
+	if tc, ok := conn.(*tls.Conn); ok && c.info.TLSVersion == "" {
+		state := tc.ConnectionState()
+		c.info.TLSVersion = tls.VersionName(state.Version)
+		c.info.TLSCipher = tls.CipherSuiteName(state.CipherSuite)
+	}
+	c.sampleConnLocked(conn)
+}
+
+// conns returns the connections seen so far
+func (c *connTracer) conns() *ConnInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info := c.info
+	info.Remotes = slices.Clone(info.Remotes)
+	return &info
+}
+
+// result returns what was recorded so far
+func (c *connTracer) result() *ConnTimings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := c.timings
+	t.DNS = slices.Clone(t.DNS)
+	t.Connect = slices.Clone(t.Connect)
+	t.TLS = slices.Clone(t.TLS)
+	t.TTFB = slices.Clone(t.TTFB)
+	return &t
+}
@@ -0,0 +1,82 @@
+package defs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The first ping opens the connection, TLS included, and the rest reuse it
+func TestHTTPPingRecordsTimings(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	s := &Server{Server: ts.URL, PingURL: "/", Client: ts.Client()}
+	res, err := s.HTTPPing(context.Background(), 4)
+	if err != nil {
+		t.Fatalf("HTTPPing: %v", err)
+	}
+
+	got := res.Timings
+	if got == nil {
+		t.Fatal("HTTPPing returned no timings")
+	}
+	if got.Requests != 4 || got.NewConns != 1 {
+		t.Errorf("timings cover %d request(s) and %d new connection(s), want 4 and 1", got.Requests, got.NewConns)
+	}
+	// an address needs no lookup
+	if len(got.DNS) != 0 || len(got.Connect) != 1 || len(got.TLS) != 1 {
+		t.Errorf("setup timings = DNS %v, connect %v, TLS %v, want a connect and a handshake", got.DNS, got.Connect, got.TLS)
+	}
+	if len(got.TTFB) != 4 {
+		t.Errorf("TTFB = %v, want one per request", got.TTFB)
+	}
+
+	conns := res.Conns
+	if len(conns.Remotes) != 1 || conns.Family() != "IPv4" {
+		t.Errorf("connected to %v (%s), want the test server over IPv4", conns.Remotes, conns.Family())
+	}
+	if conns.TLSVersion == "" || conns.TLSCipher == "" {
+		t.Errorf("TLS = %q %q, want the version and cipher negotiated", conns.TLSVersion, conns.TLSCipher)
+	}
+}
+
+func TestMergeConnInfo(t *testing.T) {
+	ping := &ConnInfo{Remotes: []string{"192.0.2.1:443"}, TLSVersion: "TLS 1.3", TLSCipher: "TLS_AES_128_GCM_SHA256"}
+	download := &ConnInfo{Remotes: []string{"192.0.2.1:443", "[2001:db8::1]:443"}}
+
+	got := MergeConnInfo(ping, nil, download)
+	if len(got.Remotes) != 2 || got.Family() != "mixed" || got.TLSVersion != "TLS 1.3" {
+		t.Errorf("MergeConnInfo = %+v (%s), want both addresses, mixed, TLS 1.3", got, got.Family())
+	}
+	if got := MergeConnInfo(); got.Family() != "" {
+		t.Errorf("Family of no connections = %q, want empty", got.Family())
+	}
+}
+
+func TestDownloadRecordsTimings(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<16))
+	}))
+	defer ts.Close()
+
+	s := &Server{Server: ts.URL, DownloadURL: "/"}
+	res, err := s.DownloadContext(context.Background(), TransferOptions{Silent: true, Requests: 2, Duration: 300 * time.Millisecond, Chunks: 1})
+	if err != nil {
+		t.Fatalf("Download: %v", err)
+	}
+	if res.Timings == nil || res.Timings.Requests == 0 || res.Timings.NewConns == 0 {
+		t.Fatalf("Download timings = %+v, want requests over new connections", res.Timings)
+	}
+	// plain HTTP to an address needs neither a lookup nor a handshake
+	if len(res.Timings.DNS) != 0 || len(res.Timings.TLS) != 0 {
+		t.Errorf("Download timings = DNS %v, TLS %v, want neither", res.Timings.DNS, res.Timings.TLS)
+	}
+	// a dial may hand its connection to another request than the one it
+	// was traced on, so there can be fewer connect times than connections
+	if n := len(res.Timings.Connect); n == 0 || n > res.Timings.NewConns {
+		t.Errorf("connect timings = %v, want up to one per new connection (%d)", res.Timings.Connect, res.Timings.NewConns)
+	}
+}
@@ -0,0 +1,513 @@
+package defs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/briandowns/spinner"
+	"github.com/librespeed/speedtest-cli/output"
+)
+
+const (
+	// sampleInterval is how often a transfer's rate is sampled
+	sampleInterval = 100 * time.Millisecond
+
+	// warmupWindow is how many samples are averaged when looking for the
+	// point the rate stops climbing, and warmupGrowth is how much the next
+	// window may still grow over the current one for the rate to count as
+	// settled
+	warmupWindow = 5
+	warmupGrowth = 0.1
+
+	// adaptiveStep is how long each stream count is given before the rate
+	// is compared with the last, and adaptiveGrowth how much it has to have
+	// grown for another stream to be added
+	adaptiveStep   = 500 * time.Millisecond
+	adaptiveGrowth = 0.1
+
+	// failedRetryDelay is how long a stream whose request failed waits
+	// before trying again, so a server that errors out instantly is not
+	// hammered
+	failedRetryDelay = 200 * time.Millisecond
+
+	// convergeSmoothing is how many samples the rolling rate that
+	// convergence is judged on averages over
+	convergeSmoothing = 10
+)
+
+// Reasons a transfer ended
+const (
+	// EndDuration means the transfer ran for its whole duration
+	EndDuration = "duration"
+	// EndConverged means the rate had settled and the transfer was stopped
+	// early
+	EndConverged = "converged"
+	// EndBudget means the transfer moved its MaxBytes and was stopped
+	EndBudget = "budget"
+	// EndInterrupted means the context the transfer was given was cancelled
+	EndInterrupted = "interrupted"
+)
+
+// TransferOptions configures a download or upload test
+type TransferOptions struct {
+	// Silent hides the progress spinner
+	Silent bool
+	// UseBytes and UseMebi change how the rate is displayed
+	UseBytes bool
+	UseMebi  bool
+	// Requests is the number of requests kept in flight
+	Requests int
+	// Adaptive starts with one request in flight and adds more while each
+	// one still raises the rate noticeably, up to MaxRequests. Requests is
+	// ignored.
+	Adaptive    bool
+	MaxRequests int
+	// Duration is how long the test runs once every request is started
+	Duration time.Duration
+	// Warmup is left out of the reported rate, so connection setup and TCP
+	// slow start do not drag it down. AutoWarmup finds the point the rate
+	// stops climbing instead.
+	Warmup     time.Duration
+	AutoWarmup bool
+	// LoadedLatency pings the server while the transfer runs, to measure
+	// how much latency a saturated link adds
+	LoadedLatency bool
+	// ConvergeWindow ends the transfer early once the rolling rate has
+	// stayed within ConvergeTolerance (a fraction of it) for this long.
+	// Duration still bounds it. Zero always runs the full duration.
+	ConvergeWindow    time.Duration
+	ConvergeTolerance float64
+	// MaxBytes ends the transfer once it has moved this many bytes, for
+	// metered links. Zero is no limit.
+	MaxBytes uint64
+	// MaxErrorRatio is the share of failed requests above which the result
+	// is marked as TooManyErrors
+	MaxErrorRatio float64
+
+	// Chunks is the number of chunks each download request asks for
+	Chunks int
+	// UploadSize is the size of each upload request in KiB
+	UploadSize    int
+	NoPreAllocate bool
+}
+
+// TransferResult is what a download or upload test measured
+type TransferResult struct {
+	// Mbps is the average rate after the warm-up
+	Mbps float64
+	// RawMbps is the average rate over the whole transfer, warm-up included
+	RawMbps float64
+	// Bytes is the total read or written, warm-up included
+	Bytes uint64
+	// Streams is the number of requests that were kept in flight
+	Streams int
+	// Requests counts how the requests made during the transfer ended
+	Requests RequestStats
+	// TooManyErrors is set when more requests failed than MaxErrorRatio
+	// allows, so the rate may not reflect the link
+	TooManyErrors bool
+	// Elapsed is how long the transfer actually ran
+	Elapsed time.Duration
+	// EndReason says why the transfer ended: EndDuration, EndConverged,
+	// EndBudget or EndInterrupted
+	EndReason string
+	// Warmup is how much of the start of the transfer Mbps leaves out
+	Warmup time.Duration
+	// Samples is the rate in Mbps over each SampleInterval, in order. The
+	// first WarmupSamples of them fall within the warm-up.
+	Samples        []float64
+	SampleInterval time.Duration
+	WarmupSamples  int
+
+	// IdlePing is the average HTTP ping in ms just before the transfer, and
+	// LoadedPings every HTTP ping in ms during it, both over a connection of
+	// their own. Nothing is recorded unless LoadedLatency was set.
+	IdlePing    float64
+	LoadedPings []float64
+
+	// Timings break the transfer's requests down into connection setup and
+	// time to first byte, and Conns describes the connections they used
+	Timings *ConnTimings
+	Conns   *ConnInfo
+	// TCP is the TCP_INFO of each connection the transfer's requests used,
+	// where the platform has it
+	TCP []TCPStats
+}
+
+// LoadedPing returns the average ping in ms during the transfer, or 0 when
+// none was measured
+func (r *TransferResult) LoadedPing() float64 {
+	if len(r.LoadedPings) == 0 {
+		return 0
+	}
+	return getAvg(r.LoadedPings)
+}
+
+// AddedLatency returns how much the transfer raised the ping, in ms
+func (r *TransferResult) AddedLatency() float64 {
+	if len(r.LoadedPings) == 0 {
+		return 0
+	}
+	return r.LoadedPing() - r.IdlePing
+}
+
+// Bufferbloat grades the added latency, or returns "" when it was not
+// measured
+func (r *TransferResult) Bufferbloat() string {
+	if len(r.LoadedPings) == 0 {
+		return ""
+	}
+	return BufferbloatGrade(r.AddedLatency())
+}
+
+// Stats summarises the rate samples taken after the warm-up
+func (r *TransferResult) Stats() ThroughputStats {
+	return NewThroughputStats(r.Samples[r.WarmupSamples:])
+}
+
+// newTransferResult reads a finished transfer's figures off its counter
+func newTransferResult(counter *BytesCounter, opts TransferOptions) *TransferResult {
+	res := &TransferResult{
+		RawMbps:        counter.AvgMbps(),
+		Bytes:          counter.Total(),
+		Elapsed:        time.Since(counter.start),
+		Samples:        counter.Samples(),
+		SampleInterval: sampleInterval,
+	}
+
+	switch {
+	case opts.AutoWarmup:
+		res.WarmupSamples = detectWarmup(res.Samples)
+	case opts.Warmup > 0:
+		res.WarmupSamples = counter.SamplesWithin(opts.Warmup)
+	}
+	// a transfer the budget or an interrupt ended within its warm-up has
+	// nothing after it to measure, and is measured on the whole instead
+	if res.WarmupSamples >= len(res.Samples) {
+		res.WarmupSamples = 0
+	}
+	if res.WarmupSamples > 0 {
+		res.Mbps, res.Warmup = counter.AvgMbpsAfter(res.WarmupSamples)
+	} else {
+		res.Mbps = res.RawMbps
+	}
+
+	return res
+}
+
+// detectWarmup returns how many samples it takes for the rate to stop
+// climbing: the start of the first window the next one does not grow
+// noticeably over. At most half the samples are ever left out, so a link that
+// never settles is still measured on the later half.
+func detectWarmup(samples []float64) int {
+	limit := len(samples) / 2
+	for i := 0; i+2*warmupWindow <= len(samples) && i < limit; i++ {
+		cur := getAvg(samples[i : i+warmupWindow])
+		next := getAvg(samples[i+warmupWindow : i+2*warmupWindow])
+		if next <= cur*(1+warmupGrowth) {
+			return i
+		}
+	}
+	return limit
+}
+
+// converged reports whether the rolling rate over the last `window`
+// samples has stayed within `tolerance`, a fraction of the latest rolling
+// rate. The rolling rate averages convergeSmoothing samples, so a single
+// short stall or burst does not decide it.
+func converged(samples []float64, window int, tolerance float64) bool {
+	if window < 1 || len(samples) < window+convergeSmoothing-1 {
+		return false
+	}
+
+	lo, hi := math.Inf(1), math.Inf(-1)
+	var latest float64
+	for end := len(samples) - window + 1; end <= len(samples); end++ {
+		latest = getAvg(samples[end-convergeSmoothing : end])
+		lo, hi = math.Min(lo, latest), math.Max(hi, latest)
+	}
+	return latest > 0 && hi-lo <= latest*tolerance
+}
+
+// transfer keeps opts.Requests requests made by do in flight for
+// opts.Duration, measuring what they move through counter. do makes one
+// request and returns what went wrong with it, if anything; it must return
+// once ctx is cancelled. Cancelling parent ends the transfer early, with
+// the result measured so far.
+func (s *Server) transfer(parent context.Context, phase string, counter *BytesCounter, opts TransferOptions, do func(ctx context.Context) error) *TransferResult {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	streams, maxStreams := opts.Requests, opts.Requests
+	if opts.Adaptive {
+		streams, maxStreams = 1, opts.MaxRequests
+	}
+
+	// a finished request hands its stream's context back, for the main loop
+	// to start the stream's next request with
+	done := make(chan context.Context, maxStreams)
+
+	var wg sync.WaitGroup
+	var tally requestTally
+
+	// spawn starts a request of the stream streamCtx belongs to; cancelling
+	// streamCtx drops the stream, and ctx the whole transfer
+	spawn := func(streamCtx context.Context) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := do(streamCtx)
+			tally.record(streamCtx, err)
+			if err != nil && streamCtx.Err() == nil {
+				output.WriteDebug("%s request failed: %s\n", phase, err)
+				select {
+				case <-time.After(failedRetryDelay):
+				case <-streamCtx.Done():
+					return
+				}
+			}
+			// let the main loop start a replacement request, but never block on it
+			// once the test is over, otherwise this goroutine is leaked
+			select {
+			case done <- streamCtx:
+			case <-streamCtx.Done():
+			}
+		}()
+	}
+
+	verb, label := "Downloading", "Download"
+	if phase == "upload" {
+		verb, label = "Uploading", "Upload"
+	}
+
+	// the baseline is taken before the transfer starts, so it is idle
+	var probe *latencyProbe
+	if opts.LoadedLatency {
+		var err error
+		if probe, err = s.newLatencyProbe(); err != nil {
+			output.WriteDebug("Cannot measure latency under load: %s\n", err)
+		}
+	}
+
+	counter.SetLimit(opts.MaxBytes)
+	counter.Start()
+	counter.StartSampling(sampleInterval)
+	var loadedPing func() float64
+	if probe != nil {
+		probe.start(ctx)
+		loadedPing = probe.avg
+	}
+	stopProgress := streamProgress(phase, counter, opts.Duration, loadedPing)
+
+	var pb *spinner.Spinner
+	if !opts.Silent {
+		pb = spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriterFile(os.Stderr))
+		pb.Prefix = verb + "...  "
+		pb.PostUpdate = func(s *spinner.Spinner) {
+			if opts.UseBytes {
+				s.Suffix = fmt.Sprintf("  %s", counter.AvgHumanize())
+			} else {
+				s.Suffix = fmt.Sprintf("  %.2f Mbps", counter.AvgMbps())
+			}
+		}
+		pb.Start()
+	}
+
+	for i := 0; i < streams; i++ {
+		spawn(ctx)
+		time.Sleep(200 * time.Millisecond)
+	}
+	timeout := time.After(opts.Duration)
+
+	// in adaptive mode, add a stream every step for as long as the last one
+	// raised the rate; once one does not, the link is saturated, that stream
+	// is dropped and the count holds for the rest of the test
+	var ramp <-chan time.Time
+	if streams < maxStreams {
+		ticker := time.NewTicker(adaptiveStep)
+		defer ticker.Stop()
+		ramp = ticker.C
+	}
+	var lastTotal, lastStep uint64
+	// dropLast stops the stream the ramp added last
+	var dropLast context.CancelFunc
+
+	// once the rate has settled, running on only repeats the measurement
+	var check <-chan time.Time
+	if opts.ConvergeWindow > 0 {
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+	window := int(opts.ConvergeWindow / sampleInterval)
+	endReason := EndDuration
+Loop:
+	for {
+		select {
+		case <-timeout:
+			cancel()
+			break Loop
+		case <-parent.Done():
+			endReason = EndInterrupted
+			break Loop
+		case streamCtx := <-done:
+			// a dropped stream may have finished a request just before
+			if streamCtx.Err() == nil {
+				spawn(streamCtx)
+			}
+		case <-counter.LimitReached():
+			output.WriteDebug("%s reached its budget of %d byte(s)\n", label, opts.MaxBytes)
+			endReason = EndBudget
+			cancel()
+			break Loop
+		case <-check:
+			// an adaptive transfer still adding streams has not settled yet,
+			// and neither has one still within its warm-up
+			if ramp != nil {
+				continue
+			}
+			samples := counter.Samples()
+			var warm int
+			switch {
+			case opts.AutoWarmup:
+				warm = detectWarmup(samples)
+			case opts.Warmup > 0:
+				if time.Since(counter.start) < opts.Warmup {
+					continue
+				}
+				warm = min(counter.SamplesWithin(opts.Warmup), len(samples))
+			}
+			if converged(samples[warm:], window, opts.ConvergeTolerance) {
+				endReason = EndConverged
+				cancel()
+				break Loop
+			}
+		case <-ramp:
+			// the step just over ran with the stream the last one added
+			total := counter.Total()
+			step := since(total, lastTotal)
+			lastTotal = total
+			if dropLast != nil && float64(step) <= float64(lastStep)*(1+adaptiveGrowth) {
+				dropLast()
+				streams--
+				output.WriteDebug("%s rate stopped rising at %d stream(s)\n", label, streams)
+				ramp = nil
+				continue
+			}
+			lastStep = step
+			if streams >= maxStreams {
+				ramp = nil
+				continue
+			}
+			// the transfer's cancel ends it with the rest if it is kept
+			streamCtx, cancelStream := context.WithCancel(ctx)
+			dropLast = cancelStream
+			spawn(streamCtx)
+			streams++
+		}
+	}
+
+	// let the cancelled requests unwind before reading the counter, so the
+	// result doesn't change under us while it's being reported
+	wg.Wait()
+	counter.StopSampling()
+	stopProgress()
+
+	res := newTransferResult(counter, opts)
+	res.Streams = streams
+	res.EndReason = endReason
+	res.Requests = tally.result()
+	res.TooManyErrors = res.Requests.Failed > 0 && res.Requests.ErrorRatio() > opts.MaxErrorRatio
+	if probe != nil {
+		res.IdlePing = probe.idle
+		res.LoadedPings = probe.wait()
+	}
+
+	if pb != nil {
+		// print the rate ourselves instead of via pb.FinalMSG: the spinner only
+		// prints it when it was actually running, which it isn't when stderr is
+		// not a terminal
+		pb.Stop()
+		rate, raw := fmt.Sprintf("%.2f Mbps", res.Mbps), fmt.Sprintf("%.2f Mbps", res.RawMbps)
+		if opts.UseBytes {
+			rate, raw = humanizeBytes(res.Mbps*counter.mbpsBase(), opts.UseMebi), humanizeBytes(res.RawMbps*counter.mbpsBase(), opts.UseMebi)
+		}
+		if res.Warmup > 0 {
+			output.WriteUI("%s rate:\t%s (%s including %.1fs warm-up)\n", label, rate, raw, res.Warmup.Seconds())
+		} else {
+			output.WriteUI("%s rate:\t%s\n", label, rate)
+		}
+		if opts.Adaptive {
+			output.WriteUI("%s streams:\t%d\n", label, res.Streams)
+		}
+		switch res.EndReason {
+		case EndConverged:
+			output.WriteUI("%s ended early:\trate stable after %.1fs\n", label, res.Elapsed.Seconds())
+		case EndBudget:
+			output.WriteUI("%s ended early:\tdata budget spent after %.1fs\n", label, res.Elapsed.Seconds())
+		case EndInterrupted:
+			output.WriteUI("%s ended early:\tinterrupted after %.1fs\n", label, res.Elapsed.Seconds())
+		}
+		if grade := res.Bufferbloat(); grade != "" {
+			output.WriteUI("%s latency:\t%.2f ms (+%.2f ms, bufferbloat grade %s)\n", label, res.LoadedPing(), res.AddedLatency(), grade)
+		}
+	}
+
+	// warn even when the rate itself is not printed: a rate measured off a
+	// struggling server must not pass for a measurement of the link
+	if res.TooManyErrors {
+		output.WriteError("%s: %d of %d request(s) failed (%s), the rate may not reflect the link\n",
+			label, res.Requests.Failed, res.Requests.Succeeded+res.Requests.Failed, res.Requests.errorSummary())
+	}
+
+	return res
+}
+
+// streamProgress emits one progress event a second while a transfer phase
+// runs, reading the rate off the phase's byte counter, and the ping under
+// load off loadedPing when it is being measured. The returned stop function
+// ends the ticker and does not return until the goroutine is done, so a late
+// progress event can never land after the next phase event.
+func streamProgress(phase string, counter *BytesCounter, duration time.Duration, loadedPing func() float64) func() {
+	if !output.StreamEnabled() {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	start := time.Now()
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				elapsed := time.Since(start).Seconds()
+				event := output.ProgressEvent{
+					Event:    "progress",
+					Phase:    phase,
+					Seconds:  math.Round(elapsed*10) / 10,
+					Mbps:     math.Round(counter.AvgMbps()*100) / 100,
+					Progress: int(math.Min(elapsed/duration.Seconds()*100, 100)),
+				}
+				if loadedPing != nil {
+					event.LoadedPing = math.Round(loadedPing()*100) / 100
+				}
+				output.WriteEvent(event)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
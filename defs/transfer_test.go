@@ -0,0 +1,295 @@
+package defs
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDetectWarmup(t *testing.T) {
+	cases := []struct {
+		name    string
+		samples []float64
+		want    int
+	}{
+		{
+			"slow start then steady",
+			[]float64{5, 10, 20, 40, 80, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100},
+			4,
+		},
+		{
+			"steady from the start",
+			[]float64{100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100},
+			0,
+		},
+		{
+			"never settles, so only half is left out",
+			[]float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+			10,
+		},
+		{"too few samples to tell", []float64{1, 2, 3}, 1},
+		{"no samples", nil, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := detectWarmup(c.samples); got != c.want {
+				t.Errorf("detectWarmup = %d, want %d", got, c.want)
+			}
+		})
+	}
+}
+
+func TestConverged(t *testing.T) {
+	steady := []float64{100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100, 100}
+	rising := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110, 120, 130, 140, 150}
+
+	cases := []struct {
+		name      string
+		samples   []float64
+		window    int
+		tolerance float64
+		want      bool
+	}{
+		{"steady", steady, 5, 0.05, true},
+		{"still rising", rising, 5, 0.05, false},
+		{"rising within a loose tolerance", rising, 2, 0.2, true},
+		{"too few samples for the window", steady[:12], 5, 0.05, false},
+		{"nothing transferred", make([]float64, 15), 5, 0.05, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := converged(c.samples, c.window, c.tolerance); got != c.want {
+				t.Errorf("converged = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTransferResultStatsLeaveOutWarmup(t *testing.T) {
+	res := &TransferResult{
+		Samples:       []float64{1, 2, 50, 50, 50},
+		WarmupSamples: 2,
+	}
+	if got := res.Stats().Min; got != 50 {
+		t.Errorf("Stats().Min = %v, want 50 with the warm-up left out", got)
+	}
+}
+
+// perConnectionLimitedServer serves downloads at a fixed rate per request, so
+// the aggregate rate only rises by adding requests: the case adaptive mode
+// exists for.
+func perConnectionLimitedServer(t *testing.T, bytesPerSecond int) *Server {
+	t.Helper()
+
+	const chunk = 4096
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, chunk)
+		pause := time.Second * chunk / time.Duration(bytesPerSecond)
+		for {
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(pause):
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return &Server{Server: ts.URL, DownloadURL: "/"}
+}
+
+func TestAdaptiveAddsStreamsWhileRateRises(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+
+	res, err := s.DownloadContext(context.Background(), TransferOptions{
+		Silent:      true,
+		Adaptive:    true,
+		MaxRequests: 3,
+		Duration:    2 * time.Second,
+		Chunks:      1,
+	})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.Streams != 3 {
+		t.Errorf("used %d stream(s), want all 3 while each adds a connection's worth", res.Streams)
+	}
+}
+
+// perHostLimitedServer serves downloads at a fixed rate shared by all of its
+// requests, as a link slower than a single connection can fill does: adding
+// requests only splits the rate between them.
+func perHostLimitedServer(t *testing.T, bytesPerSecond int) *Server {
+	t.Helper()
+
+	const chunk = 4096
+	var mu sync.Mutex
+	var next time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, chunk)
+		for {
+			// each chunk takes the next free slot on the shared link
+			mu.Lock()
+			if now := time.Now(); next.Before(now) {
+				next = now
+			}
+			next = next.Add(time.Second * chunk / time.Duration(bytesPerSecond))
+			at := next
+			mu.Unlock()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(time.Until(at)):
+			}
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	return &Server{Server: ts.URL, DownloadURL: "/"}
+}
+
+func TestAdaptiveSettlesOnOneStream(t *testing.T) {
+	s := perHostLimitedServer(t, 1<<20)
+
+	res, err := s.DownloadContext(context.Background(), TransferOptions{
+		Silent:      true,
+		Adaptive:    true,
+		MaxRequests: 4,
+		Duration:    2 * time.Second,
+		Chunks:      1,
+	})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.Streams != 1 {
+		t.Errorf("used %d stream(s), want 1 when more only share the link", res.Streams)
+	}
+}
+
+func TestFixedStreamsAreReported(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+
+	res, err := s.DownloadContext(context.Background(), TransferOptions{Silent: true, Requests: 2, Duration: 300 * time.Millisecond, Chunks: 1})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.Streams != 2 {
+		t.Errorf("Streams = %d, want 2", res.Streams)
+	}
+}
+
+func TestConvergeEndsTransferEarly(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+
+	res, err := s.DownloadContext(context.Background(), TransferOptions{
+		Silent:            true,
+		Requests:          1,
+		Duration:          10 * time.Second,
+		ConvergeWindow:    500 * time.Millisecond,
+		ConvergeTolerance: 0.2,
+		Chunks:            1,
+	})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.EndReason != EndConverged {
+		t.Errorf("EndReason = %q, want %q", res.EndReason, EndConverged)
+	}
+	if res.Elapsed >= 10*time.Second {
+		t.Errorf("ran for %s, want it to end before the duration", res.Elapsed)
+	}
+}
+
+// The rate is still climbing during the warm-up, so the transfer cannot
+// have settled before it is over
+func TestConvergeWaitsForWarmup(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+
+	res, err := s.DownloadContext(context.Background(), TransferOptions{
+		Silent:            true,
+		Requests:          1,
+		Duration:          10 * time.Second,
+		Warmup:            2 * time.Second,
+		ConvergeWindow:    500 * time.Millisecond,
+		ConvergeTolerance: 0.2,
+		Chunks:            1,
+	})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.EndReason != EndConverged {
+		t.Fatalf("EndReason = %q, want %q", res.EndReason, EndConverged)
+	}
+	if res.Elapsed < 2*time.Second+500*time.Millisecond {
+		t.Errorf("converged after %s, want a full window after the 2s warm-up", res.Elapsed)
+	}
+	if res.WarmupSamples >= len(res.Samples) || res.Stats().Median == 0 {
+		t.Errorf("%d of %d sample(s) within the warm-up, stats %+v, want samples after it", res.WarmupSamples, len(res.Samples), res.Stats())
+	}
+}
+
+func TestMaxBytesEndsTransfer(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+
+	res, err := s.DownloadContext(context.Background(), TransferOptions{Silent: true, Requests: 2, Duration: 10 * time.Second, MaxBytes: 256 << 10, Chunks: 1})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.EndReason != EndBudget {
+		t.Errorf("EndReason = %q, want %q", res.EndReason, EndBudget)
+	}
+	if res.Elapsed >= 10*time.Second {
+		t.Errorf("ran for %s, want it to stop at the budget", res.Elapsed)
+	}
+}
+
+// A budget spent within the warm-up leaves nothing after it, and the rate is
+// the whole transfer's rather than that of the last few bytes
+func TestBudgetWithinWarmupReportsWholeTransfer(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+
+	res, err := s.DownloadContext(context.Background(), TransferOptions{Silent: true, Requests: 1, Duration: 10 * time.Second, Warmup: 5 * time.Second, MaxBytes: 512 << 10, Chunks: 1})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.EndReason != EndBudget {
+		t.Fatalf("EndReason = %q, want %q", res.EndReason, EndBudget)
+	}
+	if res.WarmupSamples != 0 || res.Warmup != 0 {
+		t.Errorf("%d sample(s), %s left out as warm-up, want none", res.WarmupSamples, res.Warmup)
+	}
+	if res.Mbps != res.RawMbps {
+		t.Errorf("Mbps = %.2f, want the whole transfer's %.2f", res.Mbps, res.RawMbps)
+	}
+	if res.Stats().Max == 0 {
+		t.Errorf("Stats() = %+v, want the samples of the whole transfer", res.Stats())
+	}
+}
+
+func TestCancelledTransferKeepsWhatItMeasured(t *testing.T) {
+	s := perConnectionLimitedServer(t, 1<<20)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	res, err := s.DownloadContext(ctx, TransferOptions{Silent: true, Requests: 1, Duration: 10 * time.Second, Chunks: 1})
+	if err != nil {
+		t.Fatalf("Download returned error: %v", err)
+	}
+	if res.EndReason != EndInterrupted {
+		t.Errorf("EndReason = %q, want %q", res.EndReason, EndInterrupted)
+	}
+	if res.Bytes == 0 {
+		t.Error("nothing measured before the interruption was kept")
+	}
+}
@@ -0,0 +1,45 @@
+// Package history keeps the reports of past runs in a local SQLite database,
+// so they can be looked back on after they have scrolled away: what the link
+// did last week, on which days it was slow.
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Filter selects reports from the history. Zero fields select everything.
+type Filter struct {
+	// Since and Until bound when the reports were made, Until excluded
+	Since time.Time
+	Until time.Time
+	// Server is matched, ignoring case, against part of the server's name
+	// or URL
+	Server string
+}
+
+// DefaultPath returns where the history is kept when no path is given: under
+// $XDG_DATA_HOME, or the platform's equivalent
+func DefaultPath() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		var err error
+		switch runtime.GOOS {
+		case "windows":
+			// %LocalAppData%, as history is not worth roaming
+			dir, err = os.UserCacheDir()
+		case "darwin":
+			dir, err = os.UserConfigDir()
+		default:
+			var home string
+			home, err = os.UserHomeDir()
+			dir = filepath.Join(home, ".local", "share")
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(dir, "librespeed-cli", "history.db"), nil
+}
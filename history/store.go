@@ -0,0 +1,150 @@
+//go:build !mips && !mipsle && !mips64 && !mips64le
+
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// schemaVersion is stored as the database's user_version, so a later layout
+// can tell the databases it has to migrate
+const schemaVersion = 1
+
+// schema keeps each report whole, as the JSON --json prints, next to the
+// columns the filters need and the figures worth querying with sqlite3
+const schema = `
+CREATE TABLE IF NOT EXISTS results (
+	id             INTEGER PRIMARY KEY,
+	timestamp      INTEGER NOT NULL, -- Unix time in milliseconds
+	server_name    TEXT    NOT NULL,
+	server_url     TEXT    NOT NULL,
+	ip             TEXT    NOT NULL,
+	isp            TEXT    NOT NULL,
+	ping           REAL    NOT NULL,
+	jitter         REAL    NOT NULL,
+	download       REAL    NOT NULL,
+	upload         REAL    NOT NULL,
+	bytes_sent     INTEGER NOT NULL,
+	bytes_received INTEGER NOT NULL,
+	report         TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_timestamp ON results (timestamp);
+`
+
+// Store is an open history database
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the history at path, creating it if it does not exist yet
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	// a scheduled run may be recording while another process reads: WAL
+	// lets them, and the busy timeout covers two writers meeting
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open history %s: %w", path, err)
+	}
+	if version > schemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("history %s was written by a newer version", path)
+	}
+	if _, err := db.Exec(schema + fmt.Sprintf("PRAGMA user_version = %d;", schemaVersion)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("cannot open history %s: %w", path, err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add appends reports to the history
+func (s *Store) Add(reps []report.JSONReport) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rep := range reps {
+		b, err := json.Marshal(rep)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO results
+			(timestamp, server_name, server_url, ip, isp, ping, jitter, download, upload, bytes_sent, bytes_received, report)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rep.Timestamp.UnixMilli(), rep.Server.Name, rep.Server.URL, rep.Client.IP, rep.Client.Organization,
+			rep.Ping, rep.Jitter, rep.Download, rep.Upload, int64(rep.BytesSent), int64(rep.BytesReceived), string(b),
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Results returns the reports f selects, oldest first
+func (s *Store) Results(f Filter) ([]report.JSONReport, error) {
+	query := "SELECT report FROM results WHERE 1 = 1"
+	var args []any
+	if !f.Since.IsZero() {
+		query += " AND timestamp >= ?"
+		args = append(args, f.Since.UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		query += " AND timestamp < ?"
+		args = append(args, f.Until.UnixMilli())
+	}
+	if f.Server != "" {
+		pattern := "%" + likeEscaper.Replace(f.Server) + "%"
+		query += ` AND (server_name LIKE ? ESCAPE '\' OR server_url LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+	query += " ORDER BY timestamp, id"
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reps []report.JSONReport
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return nil, err
+		}
+		var rep report.JSONReport
+		if err := json.Unmarshal(b, &rep); err != nil {
+			return nil, err
+		}
+		reps = append(reps, rep)
+	}
+	return reps, rows.Err()
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, so a server filter
+// matches what it says
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
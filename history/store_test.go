@@ -0,0 +1,83 @@
+//go:build !mips && !mipsle && !mips64 && !mips64le
+
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+func TestStoreResults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "history.db")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	at := func(day int) time.Time {
+		return time.Date(2024, time.March, day, 12, 0, 0, 0, time.UTC)
+	}
+	// added out of order, listed oldest first
+	if err := s.Add([]report.JSONReport{
+		{Timestamp: at(3), Server: report.Server{Name: "Office", URL: "http://office.example/"}, Download: 3},
+		{Timestamp: at(1), Server: report.Server{Name: "Lab 100%", URL: "http://lab.example/"}, Download: 1},
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := s.Add([]report.JSONReport{
+		{Timestamp: at(2), Server: report.Server{Name: "Lab 1", URL: "http://lab1.example/"}, Download: 2,
+			DownloadDetails: &report.Transfer{Streams: 4}},
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	s.Close()
+
+	// the history outlives the process that wrote it
+	if s, err = Open(path); err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		name string
+		f    Filter
+		want []float64
+	}{
+		{"everything", Filter{}, []float64{1, 2, 3}},
+		{"since", Filter{Since: at(2)}, []float64{2, 3}},
+		{"until", Filter{Until: at(3)}, []float64{1, 2}},
+		{"server name", Filter{Server: "lab"}, []float64{1, 2}},
+		{"server URL", Filter{Server: "office.example"}, []float64{3}},
+		{"wildcards are literal", Filter{Server: "100%"}, []float64{1}},
+		{"no match", Filter{Server: "_"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reps, err := s.Results(tt.f)
+			if err != nil {
+				t.Fatalf("Results: %v", err)
+			}
+			var got []float64
+			for _, rep := range reps {
+				got = append(got, rep.Download)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got downloads %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got downloads %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// reports come back whole
+	reps, _ := s.Results(Filter{Server: "lab1.example"})
+	if len(reps) != 1 || reps[0].DownloadDetails == nil || reps[0].DownloadDetails.Streams != 4 {
+		t.Errorf("stored report lost its details: %+v", reps)
+	}
+}
@@ -0,0 +1,30 @@
+//go:build mips || mipsle || mips64 || mips64le
+
+package history
+
+import (
+	"errors"
+
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// Store is an open history database. The pure-Go SQLite the history is kept
+// in does not run on this platform.
+type Store struct{}
+
+// Open fails: the history is not available on this platform
+func Open(path string) (*Store, error) {
+	return nil, errors.New("history is not supported on this platform")
+}
+
+func (s *Store) Close() error {
+	return nil
+}
+
+func (s *Store) Add(reps []report.JSONReport) error {
+	return errors.New("history is not supported on this platform")
+}
+
+func (s *Store) Results(f Filter) ([]report.JSONReport, error) {
+	return nil, errors.New("history is not supported on this platform")
+}
@@ -0,0 +1,156 @@
+package history
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// worstDays is how many of the slowest days a summary lists
+const worstDays = 3
+
+// Summary describes a stretch of history at a glance. Rates are in Mbps,
+// latencies in ms, like the reports.
+type Summary struct {
+	Runs int       `json:"runs"`
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	Download Stats `json:"download"`
+	Upload   Stats `json:"upload"`
+	Ping     Stats `json:"ping"`
+	Jitter   Stats `json:"jitter"`
+
+	// WorstDays are the days with the lowest mean download, slowest first
+	WorstDays []Day `json:"worst_days"`
+}
+
+// Stats summarises one figure over the runs
+type Stats struct {
+	Mean   float64 `json:"mean"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	Max    float64 `json:"max"`
+}
+
+// Day holds the means of the runs made on one local calendar day. Download and
+// Upload are those of the runs that measured them.
+type Day struct {
+	Date     string  `json:"date"`
+	Runs     int     `json:"runs"`
+	Download float64 `json:"download"`
+	Upload   float64 `json:"upload"`
+	Ping     float64 `json:"ping"`
+}
+
+// daySums adds up the runs of a day, and counts those that measured each
+// direction
+type daySums struct {
+	Day
+	downloads, uploads int
+}
+
+// Summarize works out the summary of reps, dated in loc. Interrupted runs are
+// left out, as their figures are not complete measurements, and so is a
+// direction a run did not measure, with --no-download or --no-upload or for
+// want of data budget. Days without a download are not among the worst.
+func Summarize(reps []report.JSONReport, loc *time.Location) Summary {
+	var s Summary
+	var download, upload, ping, jitter []float64
+	days := make(map[string]*daySums)
+
+	for _, rep := range reps {
+		if rep.Interrupted {
+			continue
+		}
+
+		if s.Runs == 0 || rep.Timestamp.Before(s.From) {
+			s.From = rep.Timestamp
+		}
+		if rep.Timestamp.After(s.To) {
+			s.To = rep.Timestamp
+		}
+		s.Runs++
+
+		date := rep.Timestamp.In(loc).Format(time.DateOnly)
+		d := days[date]
+		if d == nil {
+			d = &daySums{Day: Day{Date: date}}
+			days[date] = d
+		}
+		// sums for now, divided into means below
+		d.Runs++
+		d.Ping += rep.Ping
+		ping = append(ping, rep.Ping)
+		jitter = append(jitter, rep.Jitter)
+
+		if measured(rep.Download, rep.DownloadDetails) {
+			download = append(download, rep.Download)
+			d.Download += rep.Download
+			d.downloads++
+		}
+		if measured(rep.Upload, rep.UploadDetails) {
+			upload = append(upload, rep.Upload)
+			d.Upload += rep.Upload
+			d.uploads++
+		}
+	}
+
+	s.Download = newStats(download)
+	s.Upload = newStats(upload)
+	s.Ping = newStats(ping)
+	s.Jitter = newStats(jitter)
+
+	all := make([]Day, 0, len(days))
+	for _, d := range days {
+		if d.downloads == 0 {
+			continue
+		}
+		d.Download = round(d.Download / float64(d.downloads))
+		if d.uploads > 0 {
+			d.Upload = round(d.Upload / float64(d.uploads))
+		}
+		d.Ping = round(d.Ping / float64(d.Runs))
+		all = append(all, d.Day)
+	}
+	slices.SortFunc(all, func(a, b Day) int {
+		return cmp.Or(cmp.Compare(a.Download, b.Download), cmp.Compare(a.Date, b.Date))
+	})
+	s.WorstDays = all[:min(worstDays, len(all))]
+
+	return s
+}
+
+// measured tells whether a run measured a direction: one that was skipped
+// has neither a rate nor the details of a transfer
+func measured(mbps float64, details *report.Transfer) bool {
+	return mbps != 0 || details != nil
+}
+
+func newStats(vals []float64) Stats {
+	if len(vals) == 0 {
+		return Stats{}
+	}
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	ts := defs.NewThroughputStats(vals)
+	return Stats{
+		Mean:   round(sum / float64(len(vals))),
+		Min:    ts.Min,
+		Median: round(ts.Median),
+		P90:    round(ts.P90),
+		Max:    ts.Max,
+	}
+}
+
+// round rounds to the two decimal places the reports keep
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,98 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+func TestSummarize(t *testing.T) {
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, time.March, day, hour, 0, 0, 0, time.UTC)
+	}
+	reps := []report.JSONReport{
+		{Timestamp: at(1, 9), Download: 100, Upload: 20, Ping: 10, Jitter: 1},
+		{Timestamp: at(1, 21), Download: 80, Upload: 20, Ping: 20, Jitter: 3},
+		{Timestamp: at(2, 9), Download: 10, Upload: 5, Ping: 60, Jitter: 9},
+		{Timestamp: at(3, 9), Download: 50, Upload: 10, Ping: 30, Jitter: 2},
+		{Timestamp: at(4, 9), Download: 95, Upload: 20, Ping: 10, Jitter: 1},
+		// left out: its figures are partial
+		{Timestamp: at(5, 9), Download: 1, Interrupted: true},
+	}
+
+	got := Summarize(reps, time.UTC)
+
+	want := Summary{
+		Runs:     5,
+		From:     at(1, 9),
+		To:       at(4, 9),
+		Download: Stats{Mean: 67, Min: 10, Median: 80, P90: 98, Max: 100},
+		Upload:   Stats{Mean: 15, Min: 5, Median: 20, P90: 20, Max: 20},
+		Ping:     Stats{Mean: 26, Min: 10, Median: 20, P90: 48, Max: 60},
+		Jitter:   Stats{Mean: 3.2, Min: 1, Median: 2, P90: 6.6, Max: 9},
+		WorstDays: []Day{
+			{Date: "2024-03-02", Runs: 1, Download: 10, Upload: 5, Ping: 60},
+			{Date: "2024-03-03", Runs: 1, Download: 50, Upload: 10, Ping: 30},
+			{Date: "2024-03-01", Runs: 2, Download: 90, Upload: 20, Ping: 15},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Summarize =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+// A direction a run skipped is not a 0 Mbps measurement, while one that ran
+// and moved nothing is
+func TestSummarizeLeavesOutSkippedDirections(t *testing.T) {
+	at := func(day int) time.Time {
+		return time.Date(2024, time.March, day, 9, 0, 0, 0, time.UTC)
+	}
+	reps := []report.JSONReport{
+		{Timestamp: at(1), Download: 100, Upload: 20, Ping: 10},
+		// --no-upload
+		{Timestamp: at(1), Download: 80, Ping: 10},
+		// --no-download, the only run of its day
+		{Timestamp: at(2), Upload: 10, Ping: 20},
+		// a download that ran and got nothing through
+		{Timestamp: at(3), Upload: 30, Ping: 30, DownloadDetails: &report.Transfer{}},
+	}
+
+	got := Summarize(reps, time.UTC)
+
+	if got.Runs != 4 {
+		t.Errorf("Runs = %d, want 4", got.Runs)
+	}
+	if want := (Stats{Mean: 60, Min: 0, Median: 80, P90: 96, Max: 100}); got.Download != want {
+		t.Errorf("Download = %+v, want %+v", got.Download, want)
+	}
+	if want := (Stats{Mean: 20, Min: 10, Median: 20, P90: 28, Max: 30}); got.Upload != want {
+		t.Errorf("Upload = %+v, want %+v", got.Upload, want)
+	}
+	want := []Day{
+		{Date: "2024-03-03", Runs: 1, Download: 0, Upload: 30, Ping: 30},
+		{Date: "2024-03-01", Runs: 2, Download: 90, Upload: 20, Ping: 10},
+	}
+	if !reflect.DeepEqual(got.WorstDays, want) {
+		t.Errorf("WorstDays = %+v, want %+v", got.WorstDays, want)
+	}
+}
+
+func TestSummarizeDaysInLocation(t *testing.T) {
+	// 23:30 UTC is already the next day east of it
+	reps := []report.JSONReport{
+		{Timestamp: time.Date(2024, time.March, 1, 23, 30, 0, 0, time.UTC), Download: 10},
+	}
+	got := Summarize(reps, time.FixedZone("UTC+2", 2*60*60))
+	if len(got.WorstDays) != 1 || got.WorstDays[0].Date != "2024-03-02" {
+		t.Errorf("WorstDays = %+v, want 2024-03-02", got.WorstDays)
+	}
+}
+
+func TestSummarizeNothing(t *testing.T) {
+	got := Summarize(nil, time.UTC)
+	if got.Runs != 0 || len(got.WorstDays) != 0 {
+		t.Errorf("Summarize(nil) = %+v, want an empty summary", got)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/urfave/cli/v2"
 
+	"github.com/librespeed/speedtest-cli/config"
 	"github.com/librespeed/speedtest-cli/defs"
 	"github.com/librespeed/speedtest-cli/output"
 	"github.com/librespeed/speedtest-cli/speedtest"
@@ -17,12 +22,129 @@ func main() {
 		Usage:    "Test your Internet speed with LibreSpeed",
 		Action:   speedtest.SpeedTest,
 		HideHelp: true,
+		// an option that does not parse exits like any other usage error
+		OnUsageError: speedtest.UsageError,
+		Commands: []*cli.Command{
+			{
+				Name:  "serve",
+				Usage: "Run a LibreSpeed backend for other clients to test against",
+				Description: "Serves the download, upload, ping and getIP endpoints LibreSpeed\n" +
+					"clients use, so one binary can be both ends of a test. Use\n" +
+					"--" + defs.OptionServerList + " to write a server list for --" + defs.OptionLocalJSON + " on the client.",
+				Action: speedtest.Serve,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  defs.OptionListen,
+						Usage: "`ADDRESS` to listen on",
+						Value: ":8989",
+					},
+					&cli.StringFlag{
+						Name: defs.OptionAdvertiseURL,
+						Usage: "Base `URL` clients reach this server at, for the server\n" +
+							"\tlist. Defaults to the listen address, with the host name\n" +
+							"\tin place of an unspecified address",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionServerName,
+						Usage: "Server `NAME` for the server list. Defaults to the host name",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionServerList,
+						Usage: "Write a server list naming this server to `FILE`, or to stdout for \"-\"",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionTLSCert,
+						Usage: "Serve HTTPS with the certificate chain in PEM `FILE`",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionTLSKey,
+						Usage: "Private key in PEM `FILE` for --" + defs.OptionTLSCert,
+					},
+				},
+			},
+			{
+				Name:  "exporter",
+				Usage: "Serve speed test results as Prometheus metrics",
+				Description: "/metrics runs a test the way the global options say, e.g.\n" +
+					"\"librespeed-cli --server 52 exporter\"; /probe?server=ID tests one\n" +
+					"server, for blackbox-style scrape configs. Tests run one at a time\n" +
+					"and their results are cached, so scrapes never load the link\n" +
+					"more often than --" + defs.OptionCacheTTL + " and --" + defs.OptionMinInterval + " allow.\n" +
+					"A test takes well over the default scrape timeout: raise it.",
+				Action: speedtest.Export,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  defs.OptionListen,
+						Usage: "`ADDRESS` to listen on",
+						Value: ":9469",
+					},
+					&cli.DurationFlag{
+						Name:  defs.OptionCacheTTL,
+						Usage: "Answer scrapes of the same target from its last result for `DURATION`",
+						Value: 10 * time.Minute,
+					},
+					&cli.DurationFlag{
+						Name: defs.OptionMinInterval,
+						Usage: "Never start a test sooner than `DURATION` after the last one\n" +
+							"\tended, whatever the target; scrapes get the last result\n" +
+							"\tinstead, or a failed probe when there is none",
+						Value: time.Minute,
+					},
+				},
+			},
+			{
+				Name:  "history",
+				Usage: "List and summarise the runs saved with --" + defs.OptionSaveHistory,
+				Description: "Lists the runs of the last 30 days and sums them up: means,\n" +
+					"percentiles and the slowest days. Reads the database --" + defs.OptionHistoryFile + "\n" +
+					"names, e.g. \"librespeed-cli --" + defs.OptionHistoryFile + " runs.db history\".",
+				Action: speedtest.History,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name: defs.OptionSince,
+						Usage: "Only show runs since `WHEN`: a date (2006-01-02), a time\n" +
+							"\t(2006-01-02T15:04:05Z) or how long ago (30d, 12h)",
+						Value: "30d",
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionUntil,
+						Usage: "Only show runs before `WHEN`, given as for --" + defs.OptionSince,
+					},
+					&cli.StringFlag{
+						Name:  defs.OptionMatch,
+						Usage: "Only show runs against servers whose name or URL contains `TEXT`",
+					},
+					&cli.BoolFlag{
+						Name:  defs.OptionSummary,
+						Usage: "Only show the summary",
+					},
+					&cli.BoolFlag{
+						Name:  defs.OptionJSON,
+						Usage: "Export the runs, or the summary, as JSON",
+					},
+					&cli.BoolFlag{
+						Name:  defs.OptionCSV,
+						Usage: "Export the runs as CSV",
+					},
+				},
+			},
+		},
 		Flags: []cli.Flag{
 			cli.HelpFlag,
 			&cli.BoolFlag{
 				Name:  defs.OptionVersion,
 				Usage: "Show the version number and exit",
 			},
+			&cli.StringFlag{
+				Name: defs.OptionConfig,
+				Usage: "Read options from the YAML `FILE` rather than from\n" +
+					"\t$XDG_CONFIG_HOME/librespeed-cli/config.yaml. Options given\n" +
+					"\ton the command line or in LIBRESPEED_* variables win",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionProfile,
+				Usage: "Apply the options of profile `NAME` from the config file",
+			},
 			&cli.BoolFlag{
 				Name:    defs.OptionIPv4,
 				Aliases: []string{defs.OptionIPv4Alt},
@@ -33,6 +155,12 @@ func main() {
 				Aliases: []string{defs.OptionIPv6Alt},
 				Usage:   "Force IPv6 only",
 			},
+			&cli.BoolFlag{
+				Name: defs.OptionDualStack,
+				Usage: "Test the selected server over IPv4 and then over IPv6,\n" +
+					"\tand compare the two. A family that cannot be tested, or\n" +
+					"\tdoes substantially worse, fails like a check",
+			},
 			&cli.BoolFlag{
 				Name:  defs.OptionNoDownload,
 				Usage: "Do not perform download test",
@@ -46,11 +174,32 @@ func main() {
 				Usage: "Do not use ICMP ping. ICMP doesn't work well under Linux\n" +
 					"\tat this moment, so you might want to disable it",
 			},
+			&cli.IntFlag{
+				Name:  defs.OptionPingCount,
+				Usage: "Work the ping and jitter out from `COUNT` pings",
+				Value: 10,
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionPingSamples,
+				Usage: "Include every ping's round-trip time in the --json\n" +
+					"\treport, not only their statistics",
+			},
 			&cli.IntFlag{
 				Name:  defs.OptionConcurrent,
 				Usage: "Concurrent HTTP requests being made",
 				Value: 3,
 			},
+			&cli.BoolFlag{
+				Name: defs.OptionAdaptive,
+				Usage: "Start each transfer with one HTTP request and add more\n" +
+					"\twhile each still raises the rate, instead of using\n" +
+					"\t--" + defs.OptionConcurrent + ". The number used is reported",
+			},
+			&cli.IntFlag{
+				Name:  defs.OptionMaxConcurrent,
+				Usage: "Most concurrent HTTP requests --" + defs.OptionAdaptive + " may add up to",
+				Value: 16,
+			},
 			&cli.BoolFlag{
 				Name: defs.OptionBytes,
 				Usage: "Display values in bytes instead of bits. Does not affect\n" +
@@ -106,6 +255,39 @@ func main() {
 					"\tsame reports --json prints. Speeds listed in Mbps\n" +
 					"\tand not affected by --bytes",
 			},
+			&cli.BoolFlag{
+				Name: defs.OptionInflux,
+				Usage: "Suppress verbose output, only show basic information\n" +
+					"\tin InfluxDB line protocol. Speeds listed in Mbps and\n" +
+					"\tnot affected by --bytes",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionInfluxURL,
+				Usage: "Also write the results to the InfluxDB v2 server at\n" +
+					"\t`URL`, e.g. http://localhost:8086. Needs --influx-org\n" +
+					"\tand --influx-bucket",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionInfluxOrg,
+				Usage: "InfluxDB `ORG` to write to",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionInfluxBucket,
+				Usage: "InfluxDB `BUCKET` to write to",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionInfluxToken,
+				Usage: "InfluxDB API `TOKEN` with write access to the bucket.\n" +
+					"\tAlso read from INFLUX_TOKEN; either variable keeps it\n" +
+					"\tout of the process list",
+				EnvVars: []string{"INFLUX_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name: defs.OptionPrometheusFile,
+				Usage: "Also write the results in Prometheus exposition format to\n" +
+					"\t`FILE`, for node_exporter's textfile collector. The file\n" +
+					"\tis replaced atomically, so it is never read half-written",
+			},
 			&cli.BoolFlag{
 				Name:  defs.OptionList,
 				Usage: "Display a list of LibreSpeed.org servers",
@@ -147,6 +329,47 @@ func main() {
 				Usage: "Upload and download test duration in seconds",
 				Value: 15,
 			},
+			&cli.Float64Flag{
+				Name: defs.OptionWarmup,
+				Usage: "Leave the first `SECONDS` of the download and upload tests\n" +
+					"\tout of their rates, so connection setup and TCP slow\n" +
+					"\tstart do not drag them down. The rate including them\n" +
+					"\tis reported alongside",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionAutoWarmup,
+				Usage: "Like --" + defs.OptionWarmup + ", but leave out however long the rate\n" +
+					"\ttakes to stop climbing. Cannot be used with --" + defs.OptionWarmup,
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionLoadedLatency,
+				Usage: "Keep pinging the server over a separate connection while\n" +
+					"\tthe download and upload tests run, and report how much\n" +
+					"\tlatency the saturated link added (bufferbloat)",
+			},
+			&cli.IntFlag{
+				Name: defs.OptionLossCount,
+				Usage: "Measure packet loss with a burst of `COUNT` probes after the\n" +
+					"\tping test: ICMP echoes, or HTTP requests with --" + defs.OptionNoICMP + ",\n" +
+					"\twhere only the ones that time out count as lost",
+			},
+			&cli.DurationFlag{
+				Name:  defs.OptionLossInterval,
+				Usage: "Send the packet loss probes `DURATION` apart",
+				Value: 100 * time.Millisecond,
+			},
+			&cli.Float64Flag{
+				Name: defs.OptionConverge,
+				Usage: "End the download and upload tests early once the rate has\n" +
+					"\tstayed within --" + defs.OptionConvergeTolerance + " for `SECONDS` after the warm-up.\n" +
+					"\t--" + defs.OptionDuration + " remains the upper bound",
+			},
+			&cli.Float64Flag{
+				Name: defs.OptionConvergeTolerance,
+				Usage: "How far, in `PERCENT`, the rate may move and still count as\n" +
+					"\tstable for --" + defs.OptionConverge,
+				Value: 5,
+			},
 			&cli.IntFlag{
 				Name:  defs.OptionChunks,
 				Usage: "Chunks to download from server, chunk size depends on server configuration",
@@ -157,6 +380,23 @@ func main() {
 				Usage: "Size of payload being uploaded in KiB",
 				Value: 1024,
 			},
+			&cli.Float64Flag{
+				Name: defs.OptionMaxErrorRatio,
+				Usage: "Flag a download or upload as unreliable when more than\n" +
+					"\t`PERCENT` of its requests fail or get a non-2xx status",
+				Value: 10,
+			},
+			&cli.StringFlag{
+				Name: defs.OptionMaxBytes,
+				Usage: "Stop moving data once the downloads and uploads of the whole\n" +
+					"\trun, across every server tested, reach `SIZE` (e.g. 500M,\n" +
+					"\t2G or 1GiB). Later tests are skipped and results are marked\n" +
+					"\tas budget-limited. With --" + defs.OptionDualStack + ", IPv4 and IPv6 share it",
+			},
+			&cli.StringFlag{
+				Name:  defs.OptionMaxPhaseBytes,
+				Usage: "Like --" + defs.OptionMaxBytes + ", but for each download and upload on its own",
+			},
 			&cli.BoolFlag{
 				Name: defs.OptionSecure,
 				Usage: "Force HTTPS for every test server, whichever scheme the\n" +
@@ -225,12 +465,77 @@ func main() {
 				Usage: "firewall mark to set on socket.",
 				Value: 0,
 			},
+			&cli.DurationFlag{
+				Name: defs.OptionInterval,
+				Usage: "Keep running, and test again every `DURATION` (e.g. 30m)\n" +
+					"\tafter each test started, writing every result to the\n" +
+					"\tconfigured outputs. Cannot be used with --cron",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionCron,
+				Usage: "Keep running, and test at the times the cron `EXPRESSION`\n" +
+					"\t(e.g. \"*/30 * * * *\" or @hourly) gives, in local time",
+			},
+			&cli.DurationFlag{
+				Name: defs.OptionRandomDelay,
+				Usage: "Delay each scheduled test by a random time up to\n" +
+					"\t`DURATION`, so many clients do not test at once",
+			},
+			&cli.BoolFlag{
+				Name: defs.OptionSaveHistory,
+				Usage: "Save the results to the history database, for the\n" +
+					"\thistory subcommand to look back on",
+			},
+			&cli.StringFlag{
+				Name: defs.OptionHistoryFile,
+				Usage: "Keep the history in `FILE` rather than under\n" +
+					"\t$XDG_DATA_HOME/librespeed-cli",
+			},
+			&cli.DurationFlag{
+				Name: defs.OptionServerListTTL,
+				Usage: "With --interval or --cron, reuse the server list and\n" +
+					"\tthe server selected from it for `DURATION`",
+				Value: time.Hour,
+			},
+			&cli.Float64Flag{
+				Name: defs.OptionMinDownload,
+				Usage: "Fail with exit code 3 when the download is below\n" +
+					"\t`MBPS`",
+			},
+			&cli.Float64Flag{
+				Name: defs.OptionMinUpload,
+				Usage: "Fail with exit code 3 when the upload is below\n" +
+					"\t`MBPS`",
+			},
+			&cli.Float64Flag{
+				Name:  defs.OptionMaxPing,
+				Usage: "Fail with exit code 3 when the ping is above `MS`",
+			},
+			&cli.Float64Flag{
+				Name:  defs.OptionMaxJitter,
+				Usage: "Fail with exit code 3 when the jitter is above `MS`",
+			},
 		},
 	}
+	// every option can also be set through LIBRESPEED_* and the config file
+	config.Setup(app)
+	for _, cmd := range app.Commands {
+		cmd.OnUsageError = speedtest.UsageError
+	}
+
+	// SIGINT and SIGTERM cancel the run instead of killing it, so what was
+	// measured so far still gets written out. A second signal is not caught,
+	// and kills a run that is slow to wind down.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
 	// run main function with cli options
-	err := app.Run(os.Args)
+	err := app.RunContext(ctx, os.Args)
 	if err != nil {
-		output.Fatalf("Terminated due to error: %s", err)
+		output.WriteError("Terminated due to error: %s\n", err)
+		os.Exit(speedtest.ExitCode(err))
 	}
 }
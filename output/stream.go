@@ -33,19 +33,24 @@ type PhaseEvent struct {
 // Progress is percent of the stage's configured duration that has elapsed:
 // a speed test is bounded by time, not by volume, so the byte count -- the
 // thing being measured -- cannot say how much is left, but elapsed over
-// duration can.
+// duration can. LoadedPing is the average ping in ms so far while the link
+// is saturated, when latency under load is being measured.
 type ProgressEvent struct {
-	Event    string  `json:"event"`
-	Phase    string  `json:"phase"`
-	Seconds  float64 `json:"seconds"`
-	Mbps     float64 `json:"mbps"`
-	Progress int     `json:"progress"`
+	Event      string  `json:"event"`
+	Phase      string  `json:"phase"`
+	Seconds    float64 `json:"seconds"`
+	Mbps       float64 `json:"mbps"`
+	Progress   int     `json:"progress"`
+	LoadedPing float64 `json:"loaded_ping,omitempty"`
 }
 
 // ResultEvent terminates the stream with the reports the run produced.
+// Interrupted is set when the run was stopped by a signal, and the reports
+// only cover what was measured until then.
 type ResultEvent struct {
-	Event   string      `json:"event"`
-	Reports interface{} `json:"reports"`
+	Event       string      `json:"event"`
+	Reports     interface{} `json:"reports"`
+	Interrupted bool        `json:"interrupted,omitempty"`
 }
 
 // WriteEvent writes one event as a single NDJSON line to stdout.
@@ -15,4 +15,71 @@ type CSVReport struct {
 	Upload    float64   `csv:"Upload"`
 	Share     string    `csv:"Share"`
 	IP        string    `csv:"IP"`
+
+	// the latency and loss of a phase or test that did not measure them are
+	// left empty, rather than written as 0
+	DownloadLatency      *float64 `csv:"Download Latency"`
+	UploadLatency        *float64 `csv:"Upload Latency"`
+	DownloadAddedLatency *float64 `csv:"Download Added Latency"`
+	UploadAddedLatency   *float64 `csv:"Upload Added Latency"`
+	Bufferbloat          string   `csv:"Bufferbloat"`
+	Loss                 *float64 `csv:"Loss"`
+
+	PingMin    float64 `csv:"Ping Min"`
+	PingMedian float64 `csv:"Ping Median"`
+	PingP95    float64 `csv:"Ping P95"`
+	PingMax    float64 `csv:"Ping Max"`
+	PingStdDev float64 `csv:"Ping StdDev"`
+
+	// medians over every request of the run, in ms
+	DNS     float64 `csv:"DNS"`
+	Connect float64 `csv:"Connect"`
+	TLS     float64 `csv:"TLS"`
+	TTFB    float64 `csv:"TTFB"`
+
+	// shares of the segments sent again, from TCP_INFO where it is available
+	DownloadRetransmits float64 `csv:"Download Retransmits"`
+	UploadRetransmits   float64 `csv:"Upload Retransmits"`
+}
+
+// NewCSVReport flattens a JSON report into the CSV columns, so the two
+// formats always carry the same figures.
+func NewCSVReport(rep JSONReport) CSVReport {
+	csv := CSVReport{
+		Timestamp: rep.Timestamp,
+		Name:      rep.Server.Name,
+		Address:   rep.Server.URL,
+		Ping:      rep.Ping,
+		Jitter:    rep.Jitter,
+		Download:  rep.Download,
+		Upload:    rep.Upload,
+		Share:     rep.Share,
+		IP:        rep.Client.IP,
+
+		Bufferbloat: rep.Bufferbloat,
+	}
+	if l := rep.DownloadDetails.loadedLatency(); l != nil {
+		loaded, added := l.Loaded, l.Added
+		csv.DownloadLatency, csv.DownloadAddedLatency = &loaded, &added
+	}
+	if l := rep.UploadDetails.loadedLatency(); l != nil {
+		loaded, added := l.Loaded, l.Added
+		csv.UploadLatency, csv.UploadAddedLatency = &loaded, &added
+	}
+	if d := rep.PingDetails; d != nil {
+		csv.PingMin, csv.PingMedian, csv.PingP95, csv.PingMax, csv.PingStdDev = d.Min, d.Median, d.P95, d.Max, d.StdDev
+	}
+	if rep.Loss != nil {
+		loss := rep.Loss.Percent
+		csv.Loss = &loss
+	}
+	if t := rep.Timings; t != nil {
+		csv.DNS = t.All.DNS.median()
+		csv.Connect = t.All.Connect.median()
+		csv.TLS = t.All.TLS.median()
+		csv.TTFB = t.All.TTFB.median()
+	}
+	csv.DownloadRetransmits = rep.DownloadDetails.retransmitRatio()
+	csv.UploadRetransmits = rep.UploadDetails.retransmitRatio()
+	return csv
 }
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/gocarina/gocsv"
+
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+func TestNewCSVReportCarriesLoadedLatency(t *testing.T) {
+	rep := JSONReport{
+		Download:    100,
+		Bufferbloat: "C",
+		DownloadDetails: &Transfer{
+			LoadedLatency: &LoadedLatency{Idle: 10, Loaded: 90, Added: 80, Grade: "C"},
+		},
+	}
+
+	got := NewCSVReport(rep)
+	if got.DownloadLatency == nil || *got.DownloadLatency != 90 || got.DownloadAddedLatency == nil || *got.DownloadAddedLatency != 80 {
+		t.Errorf("NewCSVReport download latency = %v, added %v, want 90 and 80", got.DownloadLatency, got.DownloadAddedLatency)
+	}
+	if got.UploadLatency != nil || got.UploadAddedLatency != nil || got.Bufferbloat != "C" {
+		t.Errorf("NewCSVReport = %+v, want no upload latency, grade C", got)
+	}
+}
+
+func TestNewCSVReportCarriesLoss(t *testing.T) {
+	rep := JSONReport{Loss: &Loss{Method: "icmp", Sent: 50, Received: 49, Percent: 2}}
+	if got := NewCSVReport(rep); got.Loss == nil || *got.Loss != 2 {
+		t.Errorf("NewCSVReport loss = %v, want 2", got.Loss)
+	}
+	if got := NewCSVReport(JSONReport{}); got.Loss != nil {
+		t.Errorf("NewCSVReport loss without a loss test = %g, want none", *got.Loss)
+	}
+}
+
+// What was not measured is an empty field, and no loss a 0
+func TestCSVLeavesUnmeasuredEmpty(t *testing.T) {
+	rep := JSONReport{Loss: &Loss{Method: "icmp", Sent: 50, Received: 50}}
+	b, err := gocsv.MarshalBytes([]CSVReport{NewCSVReport(rep)})
+	if err != nil {
+		t.Fatalf("MarshalBytes: %v", err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil || len(rows) != 2 {
+		t.Fatalf("read %d row(s), %v, want a header and a row", len(rows), err)
+	}
+	fields := map[string]string{}
+	for i, name := range rows[0] {
+		fields[name] = rows[1][i]
+	}
+	for _, name := range []string{"Download Latency", "Upload Latency", "Download Added Latency", "Upload Added Latency"} {
+		if fields[name] != "" {
+			t.Errorf("%s = %q, want it empty", name, fields[name])
+		}
+	}
+	if fields["Loss"] != "0" {
+		t.Errorf("Loss = %q, want 0", fields["Loss"])
+	}
+}
+
+func TestNewCSVReportCarriesTimings(t *testing.T) {
+	ping := &defs.ConnTimings{Requests: 3, NewConns: 1, Connect: []float64{4}, TLS: []float64{9}, TTFB: []float64{2, 2, 3}}
+	download := &defs.ConnTimings{Requests: 2, NewConns: 2, Connect: []float64{6, 8}, TTFB: []float64{10, 12}}
+	rep := JSONReport{Timings: NewTimings(ping, download, nil)}
+
+	if rep.Timings.Upload != nil || rep.Timings.All.Requests != 5 || rep.Timings.All.NewConnections != 3 {
+		t.Fatalf("NewTimings = %+v, want no upload and 5 requests over 3 connections in all", rep.Timings)
+	}
+	got := NewCSVReport(rep)
+	if got.DNS != 0 || got.Connect != 6 || got.TLS != 9 || got.TTFB != 3 {
+		t.Errorf("NewCSVReport timings = DNS %g, connect %g, TLS %g, TTFB %g, want 0, 6, 9, 3", got.DNS, got.Connect, got.TLS, got.TTFB)
+	}
+}
+
+func TestNewCSVReportCarriesRetransmits(t *testing.T) {
+	rep := JSONReport{UploadDetails: &Transfer{TCP: NewTCPInfo([]defs.TCPStats{{Retransmits: 5, SegmentsOut: 1000}})}}
+	if got := NewCSVReport(rep); got.UploadRetransmits != 0.005 || got.DownloadRetransmits != 0 {
+		t.Errorf("NewCSVReport retransmits = %g down, %g up, want 0 and 0.005", got.DownloadRetransmits, got.UploadRetransmits)
+	}
+}
@@ -0,0 +1,92 @@
+package report
+
+import (
+	"fmt"
+)
+
+const (
+	// a family's download or upload is flagged when it falls below this
+	// share of the other family's
+	dualStackMinRateRatio = 0.5
+	// a family's ping is flagged when it is both this many ms and this
+	// factor above the other family's: a few ms apart is noise on a quick
+	// link, and 50% apart is noise on a slow one
+	dualStackMaxPingAdded = 20
+	dualStackMaxPingRatio = 1.5
+	// a family's packet loss is flagged when it is this many percentage
+	// points above the other family's
+	dualStackMaxLossAdded = 2
+)
+
+// DualStack puts the results of testing one server over IPv4 and over IPv6
+// side by side. A family that could not be tested has no report, and the
+// error that stopped it instead. Flags describe a missing family or one that
+// did substantially worse than the other.
+type DualStack struct {
+	ServerID  int         `json:"server_id"`
+	Server    Server      `json:"server"`
+	IPv4      *JSONReport `json:"ipv4"`
+	IPv6      *JSONReport `json:"ipv6"`
+	IPv4Error string      `json:"ipv4_error,omitempty"`
+	IPv6Error string      `json:"ipv6_error,omitempty"`
+	Flags     []string    `json:"flags,omitempty"`
+}
+
+// NewDualStack compares the reports of a server over IPv4 and IPv6. A nil
+// report is a family that could not be tested, for the reason err gives.
+func NewDualStack(id int, server Server, v4, v6 *JSONReport, err4, err6 error) DualStack {
+	d := DualStack{ServerID: id, Server: server, IPv4: v4, IPv6: v6}
+	if v4 == nil {
+		d.IPv4Error = missingReason(err4)
+		d.Flags = append(d.Flags, "IPv4 could not be tested: "+d.IPv4Error)
+	}
+	if v6 == nil {
+		d.IPv6Error = missingReason(err6)
+		d.Flags = append(d.Flags, "IPv6 could not be tested: "+d.IPv6Error)
+	}
+	if v4 != nil && v6 != nil {
+		d.Flags = append(d.Flags, compareFamilies("IPv4", v4, "IPv6", v6)...)
+		d.Flags = append(d.Flags, compareFamilies("IPv6", v6, "IPv4", v4)...)
+	}
+	return d
+}
+
+// Reports returns the reports of the families that were tested, IPv4 first
+func (d *DualStack) Reports() []JSONReport {
+	var reps []JSONReport
+	for _, rep := range []*JSONReport{d.IPv4, d.IPv6} {
+		if rep != nil {
+			reps = append(reps, *rep)
+		}
+	}
+	return reps
+}
+
+// missingReason says why a family has no report
+func missingReason(err error) string {
+	if err == nil {
+		return "the server did not respond"
+	}
+	return err.Error()
+}
+
+// compareFamilies flags where family a did substantially worse than family
+// b. Rates that were not measured, or are zero, are not compared.
+func compareFamilies(a string, ra *JSONReport, b string, rb *JSONReport) []string {
+	var flags []string
+	rate := func(phase string, va, vb float64) {
+		if va > 0 && vb > 0 && va < vb*dualStackMinRateRatio {
+			flags = append(flags, fmt.Sprintf("%s %s %.2f Mbps is %.0f%% of %s's %.2f Mbps", a, phase, va, va*100/vb, b, vb))
+		}
+	}
+	rate("download", ra.Download, rb.Download)
+	rate("upload", ra.Upload, rb.Upload)
+
+	if ra.Ping > 0 && rb.Ping > 0 && ra.Ping-rb.Ping > dualStackMaxPingAdded && ra.Ping > rb.Ping*dualStackMaxPingRatio {
+		flags = append(flags, fmt.Sprintf("%s ping %.2f ms is %.2f ms above %s's %.2f ms", a, ra.Ping, ra.Ping-rb.Ping, b, rb.Ping))
+	}
+	if ra.Loss != nil && rb.Loss != nil && ra.Loss.Percent-rb.Loss.Percent > dualStackMaxLossAdded {
+		flags = append(flags, fmt.Sprintf("%s packet loss %.2f%% against %.2f%% over %s", a, ra.Loss.Percent, rb.Loss.Percent, b))
+	}
+	return flags
+}
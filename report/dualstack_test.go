@@ -0,0 +1,57 @@
+package report
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewDualStackFlags(t *testing.T) {
+	v4 := JSONReport{Ping: 10, Download: 100, Upload: 40, Loss: &Loss{Percent: 0}}
+
+	cases := []struct {
+		name string
+		v6   JSONReport
+		want []string
+	}{
+		{"alike", JSONReport{Ping: 12, Download: 90, Upload: 38, Loss: &Loss{Percent: 1}}, nil},
+		{"slow download", JSONReport{Ping: 10, Download: 30, Upload: 40}, []string{"IPv6 download 30.00 Mbps is 30% of IPv4's"}},
+		// 25 ms and 3.5 times above IPv4 is flagged; 2 ms above, as in "alike", is not
+		{"high ping", JSONReport{Ping: 35, Download: 100, Upload: 40}, []string{"IPv6 ping 35.00 ms is 25.00 ms above IPv4's"}},
+		{"lossy", JSONReport{Ping: 10, Download: 100, Upload: 40, Loss: &Loss{Percent: 5}}, []string{"IPv6 packet loss 5.00%"}},
+		// a family that does better is not flagged for it, the other is
+		{"IPv4 worse", JSONReport{Ping: 10, Download: 100, Upload: 100}, []string{"IPv4 upload 40.00 Mbps is 40% of IPv6's"}},
+		// an upload that was not run is not compared
+		{"no upload", JSONReport{Ping: 10, Download: 100}, nil},
+	}
+
+	for _, c := range cases {
+		got := NewDualStack(1, Server{}, &v4, &c.v6, nil, nil)
+		if len(got.Flags) != len(c.want) {
+			t.Errorf("%s: flags = %q, want %d", c.name, got.Flags, len(c.want))
+			continue
+		}
+		for i, want := range c.want {
+			if !strings.HasPrefix(got.Flags[i], want) {
+				t.Errorf("%s: flag %q, want it to start with %q", c.name, got.Flags[i], want)
+			}
+		}
+	}
+}
+
+func TestNewDualStackMissingFamily(t *testing.T) {
+	v4 := JSONReport{Ping: 10, Download: 100}
+
+	got := NewDualStack(1, Server{Name: "test"}, &v4, nil, nil, errors.New("no route to host"))
+	if got.IPv6Error != "no route to host" || len(got.Flags) != 1 || !strings.HasPrefix(got.Flags[0], "IPv6 could not be tested") {
+		t.Errorf("NewDualStack = %+v, want IPv6 flagged as missing", got)
+	}
+	if reps := got.Reports(); len(reps) != 1 || reps[0].Download != 100 {
+		t.Errorf("Reports = %+v, want the IPv4 report alone", reps)
+	}
+
+	// a server that was skipped as down has no error to give
+	if got := NewDualStack(1, Server{}, nil, &v4, nil, nil); got.IPv4Error == "" {
+		t.Error("NewDualStack left a missing IPv4 without a reason")
+	}
+}
@@ -0,0 +1,80 @@
+package report
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// InfluxMeasurement is the measurement every report is written to
+const InfluxMeasurement = "librespeed"
+
+// influxField is one field written for every report
+type influxField struct {
+	key   string
+	value func(rep JSONReport) string
+}
+
+// influxFields are read off the report, like the other formats, so the
+// figures in InfluxDB are the ones --json prints. Byte counts are integers.
+var influxFields = []influxField{
+	{"ping", func(r JSONReport) string { return influxFloat(r.Ping) }},
+	{"jitter", func(r JSONReport) string { return influxFloat(r.Jitter) }},
+	{"download", func(r JSONReport) string { return influxFloat(r.Download) }},
+	{"upload", func(r JSONReport) string { return influxFloat(r.Upload) }},
+	{"bytes_received", func(r JSONReport) string { return strconv.FormatUint(r.BytesReceived, 10) + "i" }},
+	{"bytes_sent", func(r JSONReport) string { return strconv.FormatUint(r.BytesSent, 10) + "i" }},
+	{"duration_seconds", func(r JSONReport) string { return influxFloat(r.DurationSeconds) }},
+}
+
+// WriteInflux writes the reports in InfluxDB line protocol, one line per
+// report, tagged with the server, the client's ISP and address, and the IP
+// family the test ran over
+func WriteInflux(w io.Writer, reps []JSONReport) error {
+	bw := bufio.NewWriter(w)
+	for _, rep := range reps {
+		bw.WriteString(InfluxMeasurement)
+
+		// tags must be sorted by key, and an empty one cannot be written
+		for _, tag := range [][2]string{
+			{"family", ipFamily(rep.Client.IP)},
+			{"ip", rep.Client.IP},
+			{"isp", rep.Client.Organization},
+			{"server", rep.Server.Name},
+			{"server_url", rep.Server.URL},
+		} {
+			if tag[1] == "" {
+				continue
+			}
+			bw.WriteByte(',')
+			bw.WriteString(tag[0])
+			bw.WriteByte('=')
+			bw.WriteString(influxTagEscaper.Replace(tag[1]))
+		}
+
+		for i, f := range influxFields {
+			if i == 0 {
+				bw.WriteByte(' ')
+			} else {
+				bw.WriteByte(',')
+			}
+			bw.WriteString(f.key)
+			bw.WriteByte('=')
+			bw.WriteString(f.value(rep))
+		}
+
+		bw.WriteByte(' ')
+		bw.WriteString(strconv.FormatInt(rep.Timestamp.UnixNano(), 10))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// influxTagEscaper escapes a tag value. Line protocol has no way to escape a
+// newline, so one becomes an escaped space rather than end the line early.
+var influxTagEscaper = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `, "\r", `\ `)
+
+func influxFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
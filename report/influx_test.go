@@ -0,0 +1,56 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+func TestWriteInflux(t *testing.T) {
+	tests := []struct {
+		name string
+		rep  JSONReport
+		want string
+	}{
+		{
+			name: "every tag",
+			rep: JSONReport{
+				Timestamp:       time.Unix(1700000000, 5),
+				Server:          Server{Name: "Lab A", URL: "http://a.example/"},
+				Client:          Client{defs.IPInfoResponse{IP: "192.0.2.1", Organization: "AS64500 Example, Inc."}},
+				BytesSent:       100,
+				BytesReceived:   200,
+				Ping:            12.5,
+				Jitter:          1.25,
+				Upload:          40,
+				Download:        93.5,
+				DurationSeconds: 20.5,
+			},
+			want: `librespeed,family=ipv4,ip=192.0.2.1,isp=AS64500\ Example\,\ Inc.,server=Lab\ A,server_url=http://a.example/ ` +
+				"ping=12.5,jitter=1.25,download=93.5,upload=40,bytes_received=200i,bytes_sent=100i,duration_seconds=20.5 1700000000000000005\n",
+		},
+		{
+			name: "empty tags left out",
+			rep: JSONReport{
+				Timestamp: time.Unix(1700000000, 0),
+				Server:    Server{Name: "a=b\nforged", URL: "http://b.example/"},
+			},
+			want: `librespeed,server=a\=b\ forged,server_url=http://b.example/ ` +
+				"ping=0,jitter=0,download=0,upload=0,bytes_received=0i,bytes_sent=0i,duration_seconds=0 1700000000000000000\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteInflux(&buf, []JSONReport{tt.rep}); err != nil {
+				t.Fatalf("WriteInflux: %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got  %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
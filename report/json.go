@@ -2,6 +2,7 @@ package report
 
 import (
 	"encoding/json"
+	"math"
 	"time"
 
 	"github.com/librespeed/speedtest-cli/defs"
@@ -19,6 +20,229 @@ type JSONReport struct {
 	Upload        float64   `json:"upload"`
 	Download      float64   `json:"download"`
 	Share         string    `json:"share"`
+	// DurationSeconds is how long testing the server took, from the first
+	// request to it to the report
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	// Loss is what the packet loss test counted, when one was run
+	Loss *Loss `json:"loss,omitempty"`
+	// Timings break the run's HTTP requests down into connection setup and
+	// time to first byte
+	Timings *Timings `json:"timings,omitempty"`
+	// Bufferbloat grades the worse of the latency the download and the
+	// upload added, when latency under load was measured
+	Bufferbloat string `json:"bufferbloat,omitempty"`
+	// BudgetLimited is set when the data budget stopped a download or upload
+	// early, or left no room to run it, so its rate is not a full measurement
+	BudgetLimited bool `json:"budget_limited,omitempty"`
+	// TooManyErrors is set when so many requests of a download or upload
+	// failed that its rate may not reflect the link
+	TooManyErrors bool `json:"too_many_errors,omitempty"`
+	// Interrupted is set when the run was stopped while this server was
+	// being tested; what had been measured by then is kept
+	Interrupted bool `json:"interrupted,omitempty"`
+
+	Measurement     *Measurement `json:"measurement,omitempty"`
+	PingDetails     *PingDetails `json:"ping_details,omitempty"`
+	DownloadDetails *Transfer    `json:"download_details,omitempty"`
+	UploadDetails   *Transfer    `json:"upload_details,omitempty"`
+}
+
+// Measurement records how a result was measured, which its figures alone do
+// not say: two results that look comparable may have been taken in quite
+// different ways.
+type Measurement struct {
+	ServerID int    `json:"server_id"`
+	Sponsor  string `json:"sponsor,omitempty"`
+
+	// PingMethod is "icmp" or "http". PingFallback is set when ICMP was to
+	// be used, but was not available or not answered. PingAddress is the
+	// address the ICMP echoes went to.
+	PingMethod   string `json:"ping_method"`
+	PingFallback bool   `json:"ping_fallback,omitempty"`
+	PingCount    int    `json:"ping_count"`
+	PingAddress  string `json:"ping_address,omitempty"`
+
+	// Addresses are those the test's HTTP requests connected to, and Family
+	// their IP version, "mixed" when both were used. TLSVersion and
+	// TLSCipher are empty over plain HTTP.
+	Addresses  []string `json:"addresses,omitempty"`
+	Family     string   `json:"family,omitempty"`
+	TLSVersion string   `json:"tls_version,omitempty"`
+	TLSCipher  string   `json:"tls_cipher,omitempty"`
+
+	// DownloadStreams and UploadStreams are the number of requests each phase
+	// kept in flight, none for a phase that was not run. With Adaptive, they
+	// are what the phase ramped up to, and MaxStreams the most it could have.
+	DownloadStreams int  `json:"download_streams,omitempty"`
+	UploadStreams   int  `json:"upload_streams,omitempty"`
+	Adaptive        bool `json:"adaptive,omitempty"`
+	MaxStreams      int  `json:"max_streams,omitempty"`
+	// Chunks is the number of chunks each download request asked for, their
+	// size being up to the server, and UploadSize the KiB of each upload
+	Chunks     int `json:"chunks"`
+	UploadSize int `json:"upload_size_kib"`
+}
+
+// PingDetails describes the round-trip times the ping and jitter were worked
+// out from, in ms. JitterMethod names how the jitter was; Samples are the
+// round-trip times themselves, in order, when they were asked for.
+type PingDetails struct {
+	Count        int       `json:"count"`
+	Min          float64   `json:"min"`
+	Median       float64   `json:"median"`
+	P95          float64   `json:"p95"`
+	Max          float64   `json:"max"`
+	StdDev       float64   `json:"stddev"`
+	JitterMethod string    `json:"jitter_method"`
+	Samples      []float64 `json:"samples,omitempty"`
+}
+
+// NewPingDetails builds the details of a ping test, with its samples if
+// withSamples is set
+func NewPingDetails(res *defs.PingResult, withSamples bool) *PingDetails {
+	stats := res.Stats()
+
+	d := &PingDetails{
+		Count:        len(res.Samples),
+		Min:          round(stats.Min, 2),
+		Median:       round(stats.Median, 2),
+		P95:          round(stats.P95, 2),
+		Max:          round(stats.Max, 2),
+		StdDev:       round(stats.StdDev, 2),
+		JitterMethod: res.JitterMethod,
+	}
+	if withSamples {
+		d.Samples = make([]float64, len(res.Samples))
+		for i, v := range res.Samples {
+			d.Samples[i] = round(v, 2)
+		}
+	}
+	return d
+}
+
+// Transfer describes how the rate moved during a download or upload, which
+// the average alone does not show. Rates are in Mbps. The statistics leave out
+// the warm-up, like the report's own rate; Raw is that rate with it included.
+type Transfer struct {
+	Streams          int       `json:"streams"`
+	Seconds          float64   `json:"seconds"`
+	EndReason        string    `json:"end_reason"`
+	Raw              float64   `json:"raw"`
+	WarmupSeconds    float64   `json:"warmup_seconds"`
+	Samples          []float64 `json:"samples"`
+	SampleIntervalMs int64     `json:"sample_interval_ms"`
+	WarmupSamples    int       `json:"warmup_samples"`
+	Min              float64   `json:"min"`
+	Median           float64   `json:"median"`
+	P90              float64   `json:"p90"`
+	Max              float64   `json:"max"`
+	CV               float64   `json:"cv"`
+
+	Requests      Requests       `json:"requests"`
+	LoadedLatency *LoadedLatency `json:"loaded_latency,omitempty"`
+	TCP           *TCPInfo       `json:"tcp,omitempty"`
+}
+
+// Requests counts how the requests of a download or upload ended. Cancelled
+// ones were still running when it finished; Errors breaks Failed down by HTTP
+// status or by the kind of network error.
+type Requests struct {
+	Succeeded  int            `json:"succeeded"`
+	Failed     int            `json:"failed"`
+	Cancelled  int            `json:"cancelled"`
+	ErrorRatio float64        `json:"error_ratio"`
+	Errors     map[string]int `json:"errors,omitempty"`
+}
+
+// LoadedLatency compares the ping to the server during a transfer with the
+// ping just before it, in ms. Both are HTTP pings over a connection of their
+// own, so the difference is the queueing the transfer caused.
+type LoadedLatency struct {
+	Idle   float64 `json:"idle"`
+	Loaded float64 `json:"loaded"`
+	Added  float64 `json:"added"`
+	Grade  string  `json:"grade"`
+}
+
+// Loss is the share of a burst of probes that went unanswered. Method is
+// "icmp", or "http" where the server does not answer ICMP; over HTTP only
+// probes that timed out count as lost.
+type Loss struct {
+	Method   string  `json:"method"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Percent  float64 `json:"percent"`
+}
+
+// NewLoss builds the report of a packet loss test. A nil loss, for a test
+// that was not run, stays nil.
+func NewLoss(loss *defs.Loss) *Loss {
+	if loss == nil {
+		return nil
+	}
+	return &Loss{
+		Method:   loss.Method,
+		Sent:     loss.Sent,
+		Received: loss.Received,
+		Percent:  round(loss.Percent(), 2),
+	}
+}
+
+// NewTransfer builds the details of a finished download or upload
+func NewTransfer(res *defs.TransferResult) *Transfer {
+	stats := res.Stats()
+
+	t := &Transfer{
+		Streams:          res.Streams,
+		Seconds:          round(res.Elapsed.Seconds(), 2),
+		EndReason:        res.EndReason,
+		Raw:              round(res.RawMbps, 2),
+		WarmupSeconds:    round(res.Warmup.Seconds(), 2),
+		Samples:          make([]float64, len(res.Samples)),
+		SampleIntervalMs: res.SampleInterval.Milliseconds(),
+		WarmupSamples:    res.WarmupSamples,
+		Min:              round(stats.Min, 2),
+		Median:           round(stats.Median, 2),
+		P90:              round(stats.P90, 2),
+		Max:              round(stats.Max, 2),
+		CV:               round(stats.CV, 4),
+		Requests: Requests{
+			Succeeded:  res.Requests.Succeeded,
+			Failed:     res.Requests.Failed,
+			Cancelled:  res.Requests.Cancelled,
+			ErrorRatio: round(res.Requests.ErrorRatio(), 4),
+			Errors:     res.Requests.Errors,
+		},
+		TCP: NewTCPInfo(res.TCP),
+	}
+	for i, v := range res.Samples {
+		t.Samples[i] = round(v, 2)
+	}
+	if grade := res.Bufferbloat(); grade != "" {
+		t.LoadedLatency = &LoadedLatency{
+			Idle:   round(res.IdlePing, 2),
+			Loaded: round(res.LoadedPing(), 2),
+			Added:  round(res.AddedLatency(), 2),
+			Grade:  grade,
+		}
+	}
+	return t
+}
+
+// loadedLatency returns the latency under load, if the phase ran and
+// measured it
+func (t *Transfer) loadedLatency() *LoadedLatency {
+	if t == nil {
+		return nil
+	}
+	return t.LoadedLatency
+}
+
+// round rounds val to the given number of decimal places
+func round(val float64, places int) float64 {
+	p := math.Pow10(places)
+	return math.Round(val*p) / p
 }
 
 // Server represents the speed test server's information
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"strings"
 	"testing"
+
+	"github.com/librespeed/speedtest-cli/defs"
 )
 
 func TestNewClientOrganisation(t *testing.T) {
@@ -42,3 +44,36 @@ func TestClientJSONDoesNotLeakASName(t *testing.T) {
 		t.Errorf("org not filled from as_name: %s", b)
 	}
 }
+
+func TestNewPingDetails(t *testing.T) {
+	res := &defs.PingResult{Ping: 10.5, Jitter: 1, JitterMethod: defs.JitterSmoothed, Samples: []float64{10.004, 12, 9, 11}}
+
+	got := NewPingDetails(res, false)
+	if got.Count != 4 || got.Min != 9 || got.Median != 10.5 || got.Max != 12 || got.JitterMethod != "smoothed" {
+		t.Errorf("NewPingDetails = %+v, want 4 pings from 9 to 12 ms, median 10.5", got)
+	}
+	if got.Samples != nil {
+		t.Errorf("NewPingDetails without samples kept %v", got.Samples)
+	}
+
+	if got := NewPingDetails(res, true); len(got.Samples) != 4 || got.Samples[0] != 10 {
+		t.Errorf("NewPingDetails samples = %v, want the 4 round-trip times rounded", got.Samples)
+	}
+}
+
+func TestNewTCPInfo(t *testing.T) {
+	if got := NewTCPInfo(nil); got != nil {
+		t.Errorf("NewTCPInfo without samples = %+v, want nil", got)
+	}
+
+	got := NewTCPInfo([]defs.TCPStats{
+		{Local: "192.0.2.1:50000", Retransmits: 3, SegmentsOut: 1000, RTT: 20.004},
+		{Local: "192.0.2.1:50001", Retransmits: 1, SegmentsOut: 1000, RTT: 30},
+	})
+	if len(got.Streams) != 2 || got.Streams[0].RetransmitRatio != 0.003 || got.Streams[0].RTT != 20 {
+		t.Errorf("streams = %+v, want 2, the first retransmitting 0.3%% over 20 ms", got.Streams)
+	}
+	if got.Total.Local != "" || got.Total.Retransmits != 4 || got.Total.RetransmitRatio != 0.002 || got.Total.RTT != 25 {
+		t.Errorf("total = %+v, want 4 retransmits out of 2000, median RTT 25 ms", got.Total)
+	}
+}
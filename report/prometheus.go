@@ -0,0 +1,88 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// promMetric is one metric family written for every report
+type promMetric struct {
+	name  string
+	help  string
+	value func(rep JSONReport) float64
+}
+
+// promMetrics are the figures a report carries, as gauges of the latest test.
+// Rates and latencies keep the units the other formats use, so a dashboard
+// reads the same number the command line prints.
+var promMetrics = []promMetric{
+	{"librespeed_download_mbps", "Download rate in Mbps.", func(r JSONReport) float64 { return r.Download }},
+	{"librespeed_upload_mbps", "Upload rate in Mbps.", func(r JSONReport) float64 { return r.Upload }},
+	{"librespeed_ping_ms", "Ping in milliseconds.", func(r JSONReport) float64 { return r.Ping }},
+	{"librespeed_jitter_ms", "Jitter in milliseconds.", func(r JSONReport) float64 { return r.Jitter }},
+	{"librespeed_bytes_received", "Bytes received by the download test.", func(r JSONReport) float64 { return float64(r.BytesReceived) }},
+	{"librespeed_bytes_sent", "Bytes sent by the upload test.", func(r JSONReport) float64 { return float64(r.BytesSent) }},
+	{"librespeed_test_duration_seconds", "How long testing the server took.", func(r JSONReport) float64 { return r.DurationSeconds }},
+	{"librespeed_test_timestamp_seconds", "When the test finished, as a Unix time.", func(r JSONReport) float64 {
+		return float64(r.Timestamp.UnixMilli()) / 1000
+	}},
+}
+
+// WritePrometheus writes the reports in the Prometheus text exposition
+// format, one sample of each metric per report, labelled with the server,
+// the client's ISP and the IP family the test ran over
+func WritePrometheus(w io.Writer, reps []JSONReport) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range promMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n", m.name, m.help, m.name)
+		for _, rep := range reps {
+			fmt.Fprintf(bw, "%s%s %s\n", m.name, promLabels(rep), strconv.FormatFloat(m.value(rep), 'g', -1, 64))
+		}
+	}
+	return bw.Flush()
+}
+
+// promLabels formats the labels identifying a report's test
+func promLabels(rep JSONReport) string {
+	labels := [][2]string{
+		{"server", rep.Server.Name},
+		{"server_url", rep.Server.URL},
+		{"isp", rep.Client.Organization},
+		{"family", ipFamily(rep.Client.IP)},
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l[0])
+		b.WriteString(`="`)
+		b.WriteString(promEscaper.Replace(l[1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// promEscaper escapes a label value: server names come from the server list,
+// and a quote or newline in one must not break the exposition
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// ipFamily returns "ipv4" or "ipv6" for an address, or "" when it is not one
+func ipFamily(addr string) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return "ipv4"
+	default:
+		return "ipv6"
+	}
+}
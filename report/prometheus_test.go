@@ -0,0 +1,47 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	reps := []JSONReport{
+		{
+			Timestamp: time.Unix(1700000000, 0),
+			Server:    Server{Name: `Lab "A"`, URL: "http://a.example/"},
+			Client:    Client{defs.IPInfoResponse{IP: "192.0.2.1", Organization: "AS64500 Example"}},
+			Download:  93.5,
+		},
+		{
+			Server: Server{Name: "B\nforged 1", URL: "http://b.example/"},
+			Client: Client{defs.IPInfoResponse{IP: "2001:db8::1"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, reps); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE librespeed_download_mbps gauge\n",
+		`librespeed_download_mbps{server="Lab \"A\"",server_url="http://a.example/",isp="AS64500 Example",family="ipv4"} 93.5` + "\n",
+		`librespeed_download_mbps{server="B\nforged 1",server_url="http://b.example/",isp="",family="ipv6"} 0` + "\n",
+		`librespeed_test_timestamp_seconds{server="Lab \"A\"",server_url="http://a.example/",isp="AS64500 Example",family="ipv4"} 1.7e+09` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output is missing %q:\n%s", want, out)
+		}
+	}
+
+	// every family is declared once, however many servers were tested
+	if n := strings.Count(out, "# TYPE librespeed_upload_mbps "); n != 1 {
+		t.Errorf("upload family declared %d times, want 1", n)
+	}
+}
@@ -0,0 +1,69 @@
+package report
+
+import (
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+// TCPInfo is what the kernel reported about the connections of a download or
+// upload, where the platform has TCP_INFO (Linux). Total adds the streams up,
+// but for its RTT, which is the median of theirs.
+type TCPInfo struct {
+	Total   TCPStream   `json:"total"`
+	Streams []TCPStream `json:"streams"`
+}
+
+// TCPStream is the TCP_INFO of a connection, or of all of a phase's. The
+// counts cover the phase alone. RTT is the smoothed round-trip time in ms,
+// Cwnd the congestion window in segments, DeliveryRate in Mbps, and RcvSpace
+// the receive window in bytes.
+type TCPStream struct {
+	Local           string  `json:"local,omitempty"`
+	Retransmits     int     `json:"retransmits"`
+	SegmentsOut     int     `json:"segments_out"`
+	RetransmitRatio float64 `json:"retransmit_ratio"`
+	OutOfOrder      int     `json:"out_of_order"`
+	RTT             float64 `json:"rtt"`
+	Cwnd            int     `json:"cwnd"`
+	DeliveryRate    float64 `json:"delivery_rate"`
+	RcvSpace        int     `json:"rcv_space"`
+}
+
+// NewTCPInfo builds the report of a phase's TCP_INFO. It returns nil when
+// none was read.
+func NewTCPInfo(streams []defs.TCPStats) *TCPInfo {
+	if len(streams) == 0 {
+		return nil
+	}
+	info := &TCPInfo{Total: newTCPStream(defs.AggregateTCPStats(streams))}
+	for _, s := range streams {
+		info.Streams = append(info.Streams, newTCPStream(s))
+	}
+	return info
+}
+
+func newTCPStream(s defs.TCPStats) TCPStream {
+	t := TCPStream{
+		Local:        s.Local,
+		Retransmits:  s.Retransmits,
+		SegmentsOut:  s.SegmentsOut,
+		OutOfOrder:   s.OutOfOrder,
+		RTT:          round(s.RTT, 2),
+		Cwnd:         s.Cwnd,
+		DeliveryRate: round(s.DeliveryRate, 2),
+		RcvSpace:     s.RcvSpace,
+	}
+	if s.SegmentsOut > 0 {
+		// a path losing one segment in ten thousand is worth seeing
+		t.RetransmitRatio = round(float64(s.Retransmits)/float64(s.SegmentsOut), 6)
+	}
+	return t
+}
+
+// retransmitRatio returns the share of segments the phase sent again, or 0
+// when it has no TCP_INFO
+func (t *Transfer) retransmitRatio() float64 {
+	if t == nil || t.TCP == nil {
+		return 0
+	}
+	return t.TCP.Total.RetransmitRatio
+}
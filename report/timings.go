@@ -0,0 +1,103 @@
+package report
+
+import (
+	"github.com/librespeed/speedtest-cli/defs"
+)
+
+// Timings break the HTTP requests of a run down into connection setup and
+// time to first byte, for each phase and for all of them together. A ping
+// over ICMP makes no requests, and has no timings.
+type Timings struct {
+	Ping     *PhaseTimings `json:"ping,omitempty"`
+	Download *PhaseTimings `json:"download,omitempty"`
+	Upload   *PhaseTimings `json:"upload,omitempty"`
+	All      *PhaseTimings `json:"all"`
+}
+
+// PhaseTimings summarises where the time of a phase's requests went, in ms.
+// DNS, Connect and TLS cover the requests that opened a connection, and are
+// left out when none of them took that step. TTFB runs from the request
+// having been written to the first byte of the response.
+type PhaseTimings struct {
+	Requests       int          `json:"requests"`
+	NewConnections int          `json:"new_connections"`
+	DNS            *TimingStats `json:"dns,omitempty"`
+	Connect        *TimingStats `json:"connect,omitempty"`
+	TLS            *TimingStats `json:"tls,omitempty"`
+	TTFB           *TimingStats `json:"ttfb,omitempty"`
+}
+
+// TimingStats summarises one step over the requests that took it, in ms, with
+// the percentile the ping details use
+type TimingStats struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Max    float64 `json:"max"`
+}
+
+// NewTimings builds the timings of a run from those of its phases, any of
+// which may be nil for a phase that made no requests. It returns nil when
+// none did.
+func NewTimings(ping, download, upload *defs.ConnTimings) *Timings {
+	if ping == nil && download == nil && upload == nil {
+		return nil
+	}
+
+	var all defs.ConnTimings
+	for _, t := range []*defs.ConnTimings{ping, download, upload} {
+		if t == nil {
+			continue
+		}
+		all.Requests += t.Requests
+		all.NewConns += t.NewConns
+		all.DNS = append(all.DNS, t.DNS...)
+		all.Connect = append(all.Connect, t.Connect...)
+		all.TLS = append(all.TLS, t.TLS...)
+		all.TTFB = append(all.TTFB, t.TTFB...)
+	}
+
+	return &Timings{
+		Ping:     newPhaseTimings(ping),
+		Download: newPhaseTimings(download),
+		Upload:   newPhaseTimings(upload),
+		All:      newPhaseTimings(&all),
+	}
+}
+
+func newPhaseTimings(t *defs.ConnTimings) *PhaseTimings {
+	if t == nil {
+		return nil
+	}
+	return &PhaseTimings{
+		Requests:       t.Requests,
+		NewConnections: t.NewConns,
+		DNS:            newTimingStats(t.DNS),
+		Connect:        newTimingStats(t.Connect),
+		TLS:            newTimingStats(t.TLS),
+		TTFB:           newTimingStats(t.TTFB),
+	}
+}
+
+func newTimingStats(vals []float64) *TimingStats {
+	if len(vals) == 0 {
+		return nil
+	}
+	stats := defs.NewLatencyStats(vals)
+	return &TimingStats{
+		Count:  len(vals),
+		Min:    round(stats.Min, 2),
+		Median: round(stats.Median, 2),
+		P95:    round(stats.P95, 2),
+		Max:    round(stats.Max, 2),
+	}
+}
+
+// median returns the median of s, or 0 when the step was not taken
+func (s *TimingStats) median() float64 {
+	if s == nil {
+		return 0
+	}
+	return s.Median
+}
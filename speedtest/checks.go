@@ -0,0 +1,83 @@
+package speedtest
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// thresholds are the limits every report is checked against. Zero is no
+// limit.
+type thresholds struct {
+	minDownload float64
+	minUpload   float64
+	maxPing     float64
+	maxJitter   float64
+}
+
+// thresholdsFromContext reads the limits --min-download and the like set
+func thresholdsFromContext(c *cli.Context) (thresholds, error) {
+	t := thresholds{
+		minDownload: c.Float64(defs.OptionMinDownload),
+		minUpload:   c.Float64(defs.OptionMinUpload),
+		maxPing:     c.Float64(defs.OptionMaxPing),
+		maxJitter:   c.Float64(defs.OptionMaxJitter),
+	}
+	for _, l := range []struct {
+		name  string
+		value float64
+	}{
+		{defs.OptionMinDownload, t.minDownload},
+		{defs.OptionMinUpload, t.minUpload},
+		{defs.OptionMaxPing, t.maxPing},
+		{defs.OptionMaxJitter, t.maxJitter},
+	} {
+		if l.value < 0 {
+			return thresholds{}, fmt.Errorf("option '%s' cannot be negative: %g is given", l.name, l.value)
+		}
+	}
+
+	// a phase that is not run measures zero, which is no reason to fail
+	if t.minDownload > 0 && c.Bool(defs.OptionNoDownload) {
+		return thresholds{}, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionMinDownload, defs.OptionNoDownload)
+	}
+	if t.minUpload > 0 && c.Bool(defs.OptionNoUpload) {
+		return thresholds{}, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionMinUpload, defs.OptionNoUpload)
+	}
+	return t, nil
+}
+
+// check returns what in rep breaches the limits, as sentences for people
+func (t thresholds) check(rep report.JSONReport) []string {
+	var failed []string
+	if t.minDownload > 0 && rep.Download < t.minDownload {
+		failed = append(failed, fmt.Sprintf("download %.2f Mbps is below the minimum of %g Mbps", rep.Download, t.minDownload))
+	}
+	if t.minUpload > 0 && rep.Upload < t.minUpload {
+		failed = append(failed, fmt.Sprintf("upload %.2f Mbps is below the minimum of %g Mbps", rep.Upload, t.minUpload))
+	}
+	if t.maxPing > 0 && rep.Ping > t.maxPing {
+		failed = append(failed, fmt.Sprintf("ping %.2f ms is above the maximum of %g ms", rep.Ping, t.maxPing))
+	}
+	if t.maxJitter > 0 && rep.Jitter > t.maxJitter {
+		failed = append(failed, fmt.Sprintf("jitter %.2f ms is above the maximum of %g ms", rep.Jitter, t.maxJitter))
+	}
+	return failed
+}
+
+// checkReports checks every report, and prints each breach. It returns how
+// many there were.
+func (t thresholds) checkReports(reps []report.JSONReport) int {
+	var failed int
+	for _, rep := range reps {
+		for _, msg := range t.check(rep) {
+			output.WriteError("Check failed for %s: %s\n", output.Sanitize(rep.Server.Name), msg)
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,86 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/config"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+func TestThresholdsCheck(t *testing.T) {
+	rep := report.JSONReport{Download: 48.5, Upload: 9.75, Ping: 21, Jitter: 3.2}
+
+	cases := []struct {
+		name string
+		t    thresholds
+		want []string
+	}{
+		{"no limits", thresholds{}, nil},
+		{"all met", thresholds{minDownload: 40, minUpload: 5, maxPing: 30, maxJitter: 5}, nil},
+		{"limits are inclusive", thresholds{minDownload: 48.5, maxPing: 21}, nil},
+		{"slow download", thresholds{minDownload: 50}, []string{"download 48.50 Mbps"}},
+		{"slow upload", thresholds{minUpload: 10}, []string{"upload 9.75 Mbps"}},
+		{"high ping", thresholds{maxPing: 20}, []string{"ping 21.00 ms"}},
+		{"high jitter", thresholds{maxJitter: 2.5}, []string{"jitter 3.20 ms"}},
+		{
+			"every breach is told",
+			thresholds{minDownload: 100, minUpload: 100, maxPing: 1, maxJitter: 1},
+			[]string{"download", "upload", "ping", "jitter"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := c.t.check(rep)
+			if len(got) != len(c.want) {
+				t.Fatalf("check() = %q, want %d failures", got, len(c.want))
+			}
+			for i, prefix := range c.want {
+				if !strings.HasPrefix(got[i], prefix) {
+					t.Errorf("check()[%d] = %q, want it to start with %q", i, got[i], prefix)
+				}
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"plain error", errors.New("connection refused"), ExitFailure},
+		{"usage", withExitCode(ExitUsage, errors.New("bad flag")), ExitUsage},
+		{"threshold", withExitCode(ExitThreshold, errors.New("1 check(s) failed")), ExitThreshold},
+		{"no server", withExitCode(ExitNoServer, ErrNoServer), ExitNoServer},
+		{"wrapped", fmt.Errorf("run: %w", withExitCode(ExitNoServer, ErrNoServer)), ExitNoServer},
+		{"config file", fmt.Errorf("before: %w", &config.Error{}), ExitUsage},
+		{"cli exit", cli.Exit("interrupted", ExitInterrupted), ExitInterrupted},
+		{"context", context.DeadlineExceeded, ExitFailure},
+		{"request", &url.Error{Op: "Get", URL: "http://example.com/", Err: syscall.ECONNREFUSED}, ExitNetwork},
+		{"wrapped dial", fmt.Errorf("ping: %w", &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNRESET}), ExitNetwork},
+		{"name", &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}, ExitNetwork},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := ExitCode(c.err); got != c.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", c.err, got, c.want)
+			}
+		})
+	}
+
+	if err := withExitCode(ExitUsage, nil); err != nil {
+		t.Errorf("withExitCode(ExitUsage, nil) = %v, want nil", err)
+	}
+}
@@ -0,0 +1,212 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// RunDualStack selects the servers to test like Run, then tests each of them
+// over IPv4 and then over IPv6, back to back, and compares the two. A family
+// the server cannot be reached over is flagged rather than ending the run.
+// Cancelling ctx ends the run with the comparisons so far, the last of them
+// possibly missing its IPv6 result.
+//
+// Each family is tested by a runner of its own, forced to it the way
+// ForceIPv4 and ForceIPv6 force one. MaxBytes still caps the whole run: the
+// data one family moved is carried into the next family's test.
+func (r *Runner) RunDualStack(ctx context.Context) ([]report.DualStack, error) {
+	if r.opts.ForceIPv4 || r.opts.ForceIPv6 {
+		return nil, errors.New("a dual-stack run cannot force one IP family")
+	}
+
+	servers, err := r.testServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []report.DualStack
+	// bytes moved by every family tested so far, against MaxBytes
+	var spent uint64
+	for _, server := range servers {
+		var reps [2]*report.JSONReport
+		var errs [2]error
+		for i, family := range []string{"IPv4", "IPv6"} {
+			if err := ctx.Err(); err != nil {
+				break
+			}
+			output.WriteUI("Testing over %s\n", family)
+			output.WriteEvent(output.PhaseEvent{Event: "phase", Phase: strings.ToLower(family)})
+			reps[i], errs[i] = r.runFamily(ctx, server, i == 0, spent)
+			if reps[i] != nil {
+				spent += reps[i].BytesSent + reps[i].BytesReceived
+			}
+		}
+
+		// the server list was preprocessed, so Server is the URL as tested
+		results = append(results, report.NewDualStack(server.ID, report.Server{Name: server.Name, URL: server.Server}, reps[0], reps[1], errs[0], errs[1]))
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+	}
+
+	// like Run, select anew next time when nothing could be tested
+	for _, res := range results {
+		if res.IPv4 != nil || res.IPv6 != nil {
+			return results, nil
+		}
+	}
+	r.mu.Lock()
+	r.servers = nil
+	r.mu.Unlock()
+	return results, nil
+}
+
+// runFamily tests server alone, over IPv4 or else over IPv6, with `spent`
+// bytes of the run's budget already used
+func (r *Runner) runFamily(ctx context.Context, server defs.Server, ipv4 bool, spent uint64) (*report.JSONReport, error) {
+	opts := r.opts
+	opts.ForceIPv4, opts.ForceIPv6 = ipv4, !ipv4
+	opts.Servers = []defs.Server{server}
+	opts.ServerIDs = []int{server.ID}
+	opts.ExcludeIDs = nil
+	opts.ServerListTTL = 0
+
+	fr, err := NewRunner(opts)
+	if err != nil {
+		return nil, err
+	}
+	fr.spent = spent
+	reps, err := fr.Run(ctx)
+	if len(reps) == 0 {
+		if err == nil {
+			err = fmt.Errorf("%s is not responding", server.Name)
+		}
+		return nil, err
+	}
+	// an interrupted run still has a report worth keeping
+	return &reps[0], nil
+}
+
+// runDualStack is SpeedTest for --dual-stack. The comparisons are written in
+// place of the reports, and the reports of both families saved like those of
+// any other run. A missing or substantially worse family fails like a check.
+func runDualStack(c *cli.Context, runner *Runner, checks thresholds, save func(reps []report.JSONReport) error) error {
+	results, err := runner.RunDualStack(c.Context)
+	interrupted := errors.Is(err, context.Canceled)
+	if errors.Is(err, ErrNoServer) {
+		return withExitCode(ExitNoServer, err)
+	}
+	if err != nil && !interrupted {
+		return err
+	}
+
+	var reps []report.JSONReport
+	for _, res := range results {
+		reps = append(reps, res.Reports()...)
+	}
+	writeDualStack(c, results, reps, interrupted)
+	if err := save(reps); err != nil {
+		return err
+	}
+	if interrupted {
+		return cli.Exit("Interrupted, the results measured so far were written", ExitInterrupted)
+	}
+	if len(reps) == 0 {
+		return withExitCode(ExitNoServer, ErrNoServer)
+	}
+
+	failed := checks.checkReports(reps)
+	for _, res := range results {
+		for _, flag := range res.Flags {
+			output.WriteError("Dual-stack check failed for %s: %s\n", output.Sanitize(res.Server.Name), flag)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return withExitCode(ExitThreshold, fmt.Errorf("%d check(s) failed", failed))
+	}
+	return nil
+}
+
+// writeDualStack prints the comparisons in the format the flags ask for.
+// CSV and InfluxDB have no room for a comparison, and get a row or a line for
+// each family tested instead.
+func writeDualStack(c *cli.Context, results []report.DualStack, reps []report.JSONReport, interrupted bool) {
+	rate := func(mbps float64) string {
+		if c.Bool(defs.OptionBytes) {
+			return humanizeMbps(mbps, c.Bool(defs.OptionMebiBytes))
+		}
+		return fmt.Sprintf("%.2f Mbps", mbps)
+	}
+	// the table is the human output, and --simple's
+	write := output.WriteUI
+	if c.Bool(defs.OptionSimple) {
+		write = output.WriteOut
+	}
+	for _, res := range results {
+		writeDualStackTable(write, res, rate)
+	}
+
+	if c.Bool(defs.OptionCSV) {
+		writeCSV(reps)
+	} else if c.Bool(defs.OptionJSON) {
+		if results == nil {
+			results = []report.DualStack{}
+		}
+		if b, err := json.Marshal(&results); err != nil {
+			output.WriteError("Error generating JSON report: %s\n", err)
+		} else {
+			os.Stdout.Write(b)
+			os.Stdout.WriteString("\n")
+		}
+	} else if c.Bool(defs.OptionInflux) {
+		if err := report.WriteInflux(os.Stdout, reps); err != nil {
+			output.WriteError("Error generating InfluxDB line protocol: %s\n", err)
+		}
+	} else if c.Bool(defs.OptionJSONStream) {
+		output.WriteEvent(output.ResultEvent{Event: "result", Reports: results, Interrupted: interrupted})
+	}
+}
+
+// writeDualStackTable prints the results of a comparison side by side, a
+// family that was not tested showing as "-"
+func writeDualStackTable(write func(format string, args ...interface{}), res report.DualStack, rate func(mbps float64) string) {
+	rows := []struct {
+		label string
+		cell  func(rep *report.JSONReport) string
+	}{
+		{"Ping", func(rep *report.JSONReport) string { return fmt.Sprintf("%.2f ms", rep.Ping) }},
+		{"Jitter", func(rep *report.JSONReport) string { return fmt.Sprintf("%.2f ms", rep.Jitter) }},
+		{"Download", func(rep *report.JSONReport) string { return rate(rep.Download) }},
+		{"Upload", func(rep *report.JSONReport) string { return rate(rep.Upload) }},
+		{"Packet loss", func(rep *report.JSONReport) string {
+			if rep.Loss == nil {
+				return "-"
+			}
+			return fmt.Sprintf("%.2f %%", rep.Loss.Percent)
+		}},
+		{"Client IP", func(rep *report.JSONReport) string { return output.Sanitize(rep.Client.IP) }},
+	}
+
+	write("Dual-stack results for %s:\n", output.Sanitize(res.Server.Name))
+	write("%-13s %-18s %s\n", "", "IPv4", "IPv6")
+	for _, row := range rows {
+		cells := [2]string{"-", "-"}
+		for i, rep := range []*report.JSONReport{res.IPv4, res.IPv6} {
+			if rep != nil {
+				cells[i] = row.cell(rep)
+			}
+		}
+		write("%-13s %-18s %s\n", row.label+":", cells[0], cells[1])
+	}
+}
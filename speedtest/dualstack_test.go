@@ -0,0 +1,94 @@
+package speedtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+)
+
+// The test backend listens on an IPv4 address alone, so the IPv6 run cannot
+// reach it: the comparison keeps the IPv4 result and flags IPv6 as missing.
+func TestRunDualStackFlagsMissingFamily(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	ts := newTestBackend(t)
+
+	opts := DefaultOptions()
+	opts.Servers = []defs.Server{{ID: 1, Name: "test", Server: ts.URL, DownloadURL: "garbage", UploadURL: "empty", PingURL: "empty", GetIPURL: "getIP"}}
+	opts.NoICMP = true
+	opts.NoUpload = true
+	opts.Concurrent = 1
+	opts.Duration = 300 * time.Millisecond
+	opts.Timeout = 2 * time.Second
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	results, err := r.RunDualStack(context.Background())
+	if err != nil {
+		t.Fatalf("RunDualStack: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d comparisons, want 1", len(results))
+	}
+
+	res := results[0]
+	if res.ServerID != 1 || res.Server.Name != "test" {
+		t.Errorf("compared server %d %q, want 1 \"test\"", res.ServerID, res.Server.Name)
+	}
+	if res.IPv4 == nil || res.IPv4.Download <= 0 {
+		t.Fatalf("IPv4 report = %+v, want a measured download", res.IPv4)
+	}
+	if m := res.IPv4.Measurement; m == nil || m.Family != "IPv4" {
+		t.Errorf("IPv4 measurement = %+v, want it taken over IPv4", m)
+	}
+	if res.IPv6 != nil || res.IPv6Error == "" || len(res.Flags) != 1 {
+		t.Errorf("IPv6 = %+v (%q), flags %q, want it missing and flagged", res.IPv6, res.IPv6Error, res.Flags)
+	}
+}
+
+func TestRunDualStackRejectsForcedFamily(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ForceIPv6 = true
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	if _, err := r.RunDualStack(context.Background()); err == nil {
+		t.Error("RunDualStack ran with IPv6 forced, want an error")
+	}
+}
+
+// The run's budget spans both families: what the first spent is not there
+// for the second
+func TestRunFamilyCarriesSpentBudget(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	ts := newTestBackend(t)
+
+	opts := DefaultOptions()
+	opts.NoICMP = true
+	opts.NoUpload = true
+	opts.Concurrent = 1
+	opts.Duration = 300 * time.Millisecond
+	opts.MaxBytes = 1 << 20
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	server := defs.Server{ID: 1, Name: "test", Server: ts.URL, DownloadURL: "garbage", UploadURL: "empty", PingURL: "empty", GetIPURL: "getIP"}
+	rep, err := r.runFamily(context.Background(), server, true, opts.MaxBytes)
+	if err != nil {
+		t.Fatalf("runFamily: %v", err)
+	}
+	if !rep.BudgetLimited || rep.BytesReceived != 0 {
+		t.Errorf("downloaded %d byte(s), budget-limited %t, want the download skipped", rep.BytesReceived, rep.BudgetLimited)
+	}
+}
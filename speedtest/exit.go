@@ -0,0 +1,91 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/config"
+)
+
+// Exit statuses, so a script or a CI job can tell why a run failed without
+// parsing its output
+const (
+	// ExitFailure is a test that could not complete for any reason not
+	// covered below
+	ExitFailure = 1
+	// ExitUsage is an invalid option or config file; nothing was tested
+	ExitUsage = 2
+	// ExitThreshold is a test that completed, but whose results failed a
+	// check such as --min-download
+	ExitThreshold = 3
+	// ExitNoServer is a run where no server could be reached to test
+	ExitNoServer = 4
+	// ExitNetwork is a test a network error ended: a connection that could
+	// not be made, was reset or timed out, or a name that did not resolve
+	ExitNetwork = 5
+	// ExitInterrupted is a run stopped by SIGINT or SIGTERM, after whatever
+	// it had measured was written out. It is the status a shell gives a
+	// process killed by SIGINT, and tells a partial run from a failed one.
+	ExitInterrupted = 130
+)
+
+// exitError carries the status the program should exit with after err
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode has the program exit with code after err. A nil err stays nil.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// ExitCode returns the status the program exits with after err
+func ExitCode(err error) int {
+	var ee *exitError
+	var ce *config.Error
+	var ec cli.ExitCoder
+	var ue *url.Error
+	var ne net.Error
+	switch {
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.As(err, &ce):
+		return ExitUsage
+	case errors.As(err, &ec):
+		return ec.ExitCode()
+	case errors.As(err, &ue):
+		return ExitNetwork
+	// a context's own deadline passing is not the network's doing, though
+	// it has the methods of a net.Error
+	case errors.As(err, &ne) && !errors.Is(err, context.DeadlineExceeded):
+		return ExitNetwork
+	}
+	return ExitFailure
+}
+
+// UsageError is the app's OnUsageError. It prints what cli would, a
+// subcommand's help included, but has the program exit with ExitUsage.
+func UsageError(c *cli.Context, err error, isSubcommand bool) error {
+	fmt.Fprintf(c.App.Writer, "Incorrect Usage: %s\n\n", err)
+	if lineage := c.Lineage(); isSubcommand && len(lineage) > 1 {
+		_ = cli.ShowCommandHelp(lineage[1], c.Command.Name)
+	}
+	return withExitCode(ExitUsage, err)
+}
@@ -0,0 +1,211 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// errRateLimited is returned for a probe that would need a test before the
+// minimum interval since the last one has passed
+var errRateLimited = errors.New("too soon after the last test")
+
+// Export serves the results of speed tests to Prometheus until interrupted.
+// /metrics tests the way the global flags say, /probe?server=<id> tests one
+// server; either returns a cached result while it is fresh enough.
+func Export(c *cli.Context) error {
+	if c.Bool(defs.OptionDebug) {
+		output.SetDebug(true)
+	} else {
+		// the test's progress has no one to show it to
+		output.SetQuiet(true)
+	}
+
+	opts, err := optionsFromContext(c)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	// validate the options now rather than on the first scrape
+	if _, err := NewRunner(opts); err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+
+	e := &exporter{
+		ctx:         c.Context,
+		opts:        opts,
+		cacheTTL:    c.Duration(defs.OptionCacheTTL),
+		minInterval: c.Duration(defs.OptionMinInterval),
+		cache:       make(map[string]*probeResult),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.serveMetrics)
+	mux.HandleFunc("/probe", e.serveProbe)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "librespeed-cli exporter\n\n/metrics        tests as the command line flags say\n/probe?server=N tests server N\n")
+	})
+
+	srv := &http.Server{
+		Addr:              c.String(defs.OptionListen),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	// stop accepting and let the scrapes in flight finish once interrupted
+	shutdown := make(chan error, 1)
+	go func() {
+		<-c.Context.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	output.WriteError("Exporting metrics at %s\n", srv.Addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// serving stops as soon as the shutdown starts, before it is over
+	if err := <-shutdown; err != nil {
+		return fmt.Errorf("scrapes still in flight when shutting down: %w", err)
+	}
+	return nil
+}
+
+// exporter runs the tests scrapes ask for, one at a time, and remembers the
+// result of each so a burst of scrapes does not turn into a burst of tests
+type exporter struct {
+	// ctx bounds every test: a test outlives the scrape that started it, so
+	// a scrape timing out still leaves a result for the next one
+	ctx         context.Context
+	opts        Options
+	cacheTTL    time.Duration
+	minInterval time.Duration
+
+	// mu is held for the whole of a test, so concurrent scrapes queue for
+	// it and then find its result cached
+	mu          sync.Mutex
+	cache       map[string]*probeResult
+	lastTest    time.Time
+	tests       int
+	failures    int
+	rateLimited int
+}
+
+// probeResult is the outcome of one test
+type probeResult struct {
+	reps []report.JSONReport
+	err  error
+	at   time.Time
+}
+
+// probe returns the result for target, testing only when the cached one is
+// older than cacheTTL, and no sooner than minInterval after the last test.
+// ids selects the servers; nil keeps the configured selection.
+func (e *exporter) probe(target string, ids []int) *probeResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cached := e.cache[target]
+	if cached != nil && time.Since(cached.at) < e.cacheTTL {
+		return cached
+	}
+	if !e.lastTest.IsZero() && time.Since(e.lastTest) < e.minInterval {
+		e.rateLimited++
+		if cached != nil {
+			return cached
+		}
+		return &probeResult{err: errRateLimited, at: time.Now()}
+	}
+
+	opts := e.opts
+	if ids != nil {
+		opts.ServerIDs, opts.ExcludeIDs = ids, nil
+	}
+
+	res := &probeResult{}
+	var runner *Runner
+	if runner, res.err = NewRunner(opts); res.err == nil {
+		res.reps, res.err = runner.Run(e.ctx)
+	}
+	if res.err == nil && len(res.reps) == 0 {
+		res.err = ErrNoServer
+	}
+	res.at = time.Now()
+
+	e.lastTest = res.at
+	e.tests++
+	if res.err != nil {
+		e.failures++
+		output.WriteError("Test of %q failed: %s\n", target, res.err)
+	}
+	e.cache[target] = res
+	return res
+}
+
+// serveMetrics tests the configured servers, and adds the exporter's own
+// counters
+func (e *exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	res := e.probe("", nil)
+
+	e.mu.Lock()
+	counters := fmt.Sprintf("# HELP librespeed_tests_total Speed tests run.\n# TYPE librespeed_tests_total counter\nlibrespeed_tests_total %d\n"+
+		"# HELP librespeed_test_failures_total Speed tests that failed.\n# TYPE librespeed_test_failures_total counter\nlibrespeed_test_failures_total %d\n"+
+		"# HELP librespeed_probes_rate_limited_total Probes refused a new test because the last one was too recent.\n# TYPE librespeed_probes_rate_limited_total counter\nlibrespeed_probes_rate_limited_total %d\n",
+		e.tests, e.failures, e.rateLimited)
+	e.mu.Unlock()
+
+	writeProbe(w, "librespeed_up", "Whether the last test succeeded.", res, counters)
+}
+
+// serveProbe tests one server, blackbox style: the result is only about
+// that server
+func (e *exporter) serveProbe(w http.ResponseWriter, r *http.Request) {
+	param := r.URL.Query().Get("server")
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		http.Error(w, "server must be a server ID", http.StatusBadRequest)
+		return
+	}
+
+	res := e.probe(param, []int{id})
+	writeProbe(w, "librespeed_probe_success", "Whether the probe's test succeeded.", res, "")
+}
+
+// writeProbe writes a test's result as metrics, after the metrics in extra.
+// A failed test still answers 200, with `success` at 0, so Prometheus records
+// the failure rather than a failed scrape.
+func writeProbe(w http.ResponseWriter, success, help string, res *probeResult, extra string) {
+	var buf bytes.Buffer
+	buf.WriteString(extra)
+
+	ok := 0
+	if res.err == nil {
+		ok = 1
+	}
+	fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", success, help, success, success, ok)
+
+	if res.err == nil {
+		if err := report.WritePrometheus(&buf, res.reps); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,78 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+)
+
+func newTestExporter(t *testing.T, cacheTTL, minInterval time.Duration) *exporter {
+	t.Helper()
+
+	ts := newTestBackend(t)
+	opts := DefaultOptions()
+	opts.Servers = []defs.Server{{ID: 1, Name: "test", Server: ts.URL, DownloadURL: "garbage", UploadURL: "empty", PingURL: "empty", GetIPURL: "getIP"}}
+	opts.ServerIDs = []int{1}
+	opts.NoICMP = true
+	opts.Concurrent = 1
+	opts.Duration = 200 * time.Millisecond
+	opts.UploadSize = 64
+
+	return &exporter{
+		ctx:         context.Background(),
+		opts:        opts,
+		cacheTTL:    cacheTTL,
+		minInterval: minInterval,
+		cache:       make(map[string]*probeResult),
+	}
+}
+
+func TestExporterCachesResults(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	e := newTestExporter(t, time.Hour, 0)
+
+	for range 3 {
+		rec := httptest.NewRecorder()
+		e.serveMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body := rec.Body.String()
+		if !strings.Contains(body, "librespeed_up 1\n") || !strings.Contains(body, `librespeed_download_mbps{server="test"`) {
+			t.Fatalf("metrics are missing the test's result:\n%s", body)
+		}
+	}
+	if e.tests != 1 {
+		t.Errorf("ran %d tests for 3 scrapes within the cache TTL, want 1", e.tests)
+	}
+}
+
+func TestExporterRateLimitsNewTargets(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	e := newTestExporter(t, time.Hour, time.Hour)
+
+	if res := e.probe("", nil); res.err != nil {
+		t.Fatalf("first probe: %v", res.err)
+	}
+	// another target needs a test of its own, and it is too soon for one
+	res := e.probe("1", []int{1})
+	if !errors.Is(res.err, errRateLimited) {
+		t.Errorf("second target's probe error = %v, want it rate-limited", res.err)
+	}
+	if e.tests != 1 || e.rateLimited != 1 {
+		t.Errorf("%d test(s), %d rate-limited, want 1 and 1", e.tests, e.rateLimited)
+	}
+
+	rec := httptest.NewRecorder()
+	e.serveProbe(rec, httptest.NewRequest("GET", "/probe?server=1", nil))
+	if body := rec.Body.String(); !strings.Contains(body, "librespeed_probe_success 0\n") {
+		t.Errorf("rate-limited probe did not report failure:\n%s", body)
+	}
+}
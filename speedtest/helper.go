@@ -2,6 +2,7 @@ package speedtest
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,59 +10,73 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
-	"github.com/gocarina/gocsv"
 	"github.com/librespeed/speedtest-cli/defs"
 	"github.com/librespeed/speedtest-cli/output"
 	"github.com/librespeed/speedtest-cli/report"
-	"github.com/urfave/cli/v2"
 )
 
 const (
 	// the default ping count for measuring ping and jitter
 	pingCount = 10
+	// how long a packet loss probe may go unanswered before it is lost
+	lossTimeout = time.Second
 )
 
-// doSpeedTest is where the actual speed test happens
-func doSpeedTest(c *cli.Context, servers []defs.Server, telemetryServer defs.TelemetryServer, network string, silent bool, noICMP bool) error {
+// doSpeedTest is where the actual speed test happens. Once ctx is cancelled
+// the phase running is cut short and the rest are skipped; the reports so far,
+// the last marked as interrupted, are returned along with ctx's error.
+func (r *Runner) doSpeedTest(ctx context.Context, servers []defs.Server) ([]report.JSONReport, error) {
 	if serverCount := len(servers); serverCount > 1 {
 		output.WriteUI("Testing against %d servers\n", serverCount)
 	}
 
-	var reps_json []report.JSONReport
-	var reps_csv []report.CSVReport
+	silent := output.IsQuiet()
+	var reps []report.JSONReport
+	// bytes moved by every download and upload so far, against MaxBytes
+	spent := r.spent
 
 	// fetch current user's IP info
 	for _, currentServer := range servers {
+		if err := ctx.Err(); err != nil {
+			return reps, err
+		}
+
+		serverStart := time.Now()
+
 		// get telemetry level
-		currentServer.TLog.SetLevel(telemetryServer.GetLevel())
+		currentServer.TLog.SetLevel(r.opts.Telemetry.GetLevel())
 
 		u, err := currentServer.GetURL()
 		if err != nil {
 			output.WriteError("Failed to get server URL: %s\n", err)
-			return err
+			return nil, err
 		}
 
 		output.WriteUI("Selected server: %s [%s]\n", output.Sanitize(currentServer.Name), output.Sanitize(u.Hostname()))
-output.WriteDebug("Testing against %s (%s)\n", output.Sanitize(currentServer.Name), output.Sanitize(u.String()))
+		output.WriteDebug("Testing against %s (%s)\n", output.Sanitize(currentServer.Name), output.Sanitize(u.String()))
 
 		if sponsorMsg := currentServer.Sponsor(); sponsorMsg != "" {
 			output.WriteUI("Sponsored by: %s\n", output.Sanitize(sponsorMsg))
 		}
 
-		if currentServer.IsUp() {
+		if currentServer.IsUpContext(ctx) {
 			output.WriteDebug("Fetching IP info\n")
-			ispInfo, err := currentServer.GetIPInfo(c.String(defs.OptionDistance))
+			ispInfo, err := currentServer.GetIPInfoContext(ctx, r.opts.DistanceUnit)
+			if ctx.Err() != nil {
+				return reps, ctx.Err()
+			}
 			if err != nil {
 				output.WriteError("Failed to get IP info: %s\n", err)
-				return err
+				return nil, err
 			}
 			output.WriteUI("You're testing from: %s\n", output.Sanitize(ispInfo.ProcessedString))
-output.WriteDebug("IP info: %s\n", output.Sanitize(ispInfo.ProcessedString))
+			output.WriteDebug("IP info: %s\n", output.Sanitize(ispInfo.ProcessedString))
 
 			// get ping and jitter value
 			var pb *spinner.Spinner
@@ -72,21 +87,28 @@ output.WriteDebug("IP info: %s\n", output.Sanitize(ispInfo.ProcessedString))
 			}
 
 			// skip ICMP if option given
-			currentServer.NoICMP = noICMP
+			currentServer.NoICMP = r.noICMP
 
 			// The spinner is the only sign of progress, and it is not started
 			// in silent mode, so --json, --csv and --simple runs otherwise show
 			// nothing at all until they finish. Report each phase under --debug
 			// instead, with the timings and counts the spinner cannot carry.
-			output.WriteDebug("Ping test starting: %d pings, ICMP: %t\n", pingCount, !noICMP)
+			output.WriteDebug("Ping test starting: %d pings, ICMP: %t\n", r.opts.PingCount, !r.noICMP)
 			output.WriteEvent(output.PhaseEvent{Event: "phase", Phase: "ping"})
 			pingStart := time.Now()
 
-			p, jitter, err := currentServer.ICMPPingAndJitter(pingCount, c.String(defs.OptionSource), network)
+			pingRes, err := currentServer.ICMPPing(ctx, r.opts.PingCount, r.opts.Source, r.network)
+			if ctx.Err() != nil {
+				if pb != nil {
+					pb.Stop()
+				}
+				return reps, ctx.Err()
+			}
 			if err != nil {
 				output.WriteError("Failed to get ping and jitter: %s\n", err)
-				return err
+				return nil, err
 			}
+			p, jitter := pingRes.Ping, pingRes.Jitter
 
 			output.WriteDebug("Ping test finished in %s: ping %.2f ms, jitter %.2f ms\n", time.Since(pingStart).Round(time.Millisecond), p, jitter)
 
@@ -96,125 +118,163 @@ output.WriteDebug("IP info: %s\n", output.Sanitize(ispInfo.ProcessedString))
 				// isn't when stderr is not a terminal
 				pb.Stop()
 				output.WriteUI("Ping: %.2f ms\tJitter: %.2f ms\n", p, jitter)
+				stats := pingRes.Stats()
+				output.WriteUI("Ping range:\tmin %.2f ms, median %.2f ms, p95 %.2f ms, max %.2f ms over %d ping(s)\n",
+					stats.Min, stats.Median, stats.P95, stats.Max, len(pingRes.Samples))
+			}
+			writeTimings("Ping", pingRes.Timings)
+
+			// a loss test that fails leaves the report without loss rather
+			// than failing a test that has measured everything else
+			var loss *defs.Loss
+			if r.opts.LossCount > 0 && ctx.Err() == nil {
+				loss = r.measureLoss(ctx, &currentServer, silent)
 			}
 
 			// get download value
 			var downloadValue float64
 			var bytesRead uint64
-			if c.Bool(defs.OptionNoDownload) {
+			var downloadDetails *report.Transfer
+			var downloadTimings, uploadTimings *defs.ConnTimings
+			var downloadConns, uploadConns *defs.ConnInfo
+			// the latency each phase added, when it was measured
+			var loadedAdded []float64
+			var budgetLimited, tooManyErrors bool
+			if r.opts.NoDownload {
 				output.WriteUI("Download test is disabled\n")
 				output.WriteDebug("Download test skipped\n")
+			} else if ctx.Err() != nil {
+				output.WriteDebug("Download test skipped: interrupted\n")
+			} else if limit, ok := r.phaseBudget(spent); !ok {
+				output.WriteUI("Download test skipped: data budget spent\n")
+				budgetLimited = true
 			} else {
-				output.WriteDebug("Download test starting: %d stream(s), %d chunk(s), up to %ds\n", c.Int(defs.OptionConcurrent), c.Int(defs.OptionChunks), c.Int(defs.OptionDuration))
+				output.WriteDebug("Download test starting: %s, %d chunk(s), up to %s\n", r.streamsDebug(), r.opts.Chunks, r.opts.Duration)
 				output.WriteEvent(output.PhaseEvent{Event: "phase", Phase: "download"})
 				downloadStart := time.Now()
 
-				download, br, err := currentServer.Download(silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), c.Int(defs.OptionChunks), time.Duration(c.Int(defs.OptionDuration))*time.Second)
+				opts := r.transferOptions(silent)
+				opts.MaxBytes = limit
+				res, err := currentServer.DownloadContext(ctx, opts)
 				if err != nil {
 					output.WriteError("Failed to get download speed: %s\n", err)
-					return err
+					return nil, err
+				}
+				downloadValue = res.Mbps
+				bytesRead = res.Bytes
+				spent += res.Bytes
+				budgetLimited = res.EndReason == defs.EndBudget
+				tooManyErrors = res.TooManyErrors
+				downloadDetails = report.NewTransfer(res)
+				downloadTimings, downloadConns = res.Timings, res.Conns
+				writeTimings("Download", res.Timings)
+				writeTCP("Download", res.TCP)
+				if res.Bufferbloat() != "" {
+					loadedAdded = append(loadedAdded, res.AddedLatency())
 				}
-				downloadValue = download
-				bytesRead = br
 
-				output.WriteDebug("Download test finished in %s: %s, %d byte(s) received\n", time.Since(downloadStart).Round(time.Millisecond), humanizeRate(download, c), br)
+				output.WriteDebug("Download test finished in %s: %s, %d byte(s) received\n", time.Since(downloadStart).Round(time.Millisecond), r.humanizeRate(res.Mbps), res.Bytes)
+				r.writeStatsDebug("Download", res)
 			}
 
 			// get upload value
 			var uploadValue float64
 			var bytesWritten uint64
-			if c.Bool(defs.OptionNoUpload) {
+			var uploadDetails *report.Transfer
+			if r.opts.NoUpload {
 				output.WriteUI("Upload test is disabled\n")
 				output.WriteDebug("Upload test skipped\n")
+			} else if ctx.Err() != nil {
+				output.WriteDebug("Upload test skipped: interrupted\n")
+			} else if limit, ok := r.phaseBudget(spent); !ok {
+				output.WriteUI("Upload test skipped: data budget spent\n")
+				budgetLimited = true
 			} else {
-				output.WriteDebug("Upload test starting: %d stream(s), %d KiB per request, up to %ds\n", c.Int(defs.OptionConcurrent), c.Int(defs.OptionUploadSize), c.Int(defs.OptionDuration))
+				output.WriteDebug("Upload test starting: %s, %d KiB per request, up to %s\n", r.streamsDebug(), r.opts.UploadSize, r.opts.Duration)
 				output.WriteEvent(output.PhaseEvent{Event: "phase", Phase: "upload"})
 				uploadStart := time.Now()
 
-				upload, bw, err := currentServer.Upload(c.Bool(defs.OptionNoPreAllocate), silent, c.Bool(defs.OptionBytes), c.Bool(defs.OptionMebiBytes), c.Int(defs.OptionConcurrent), c.Int(defs.OptionUploadSize), time.Duration(c.Int(defs.OptionDuration))*time.Second)
+				opts := r.transferOptions(silent)
+				opts.MaxBytes = limit
+				res, err := currentServer.UploadContext(ctx, opts)
 				if err != nil {
 					output.WriteError("Failed to get upload speed: %s\n", err)
-					return err
+					return nil, err
+				}
+				uploadValue = res.Mbps
+				bytesWritten = res.Bytes
+				spent += res.Bytes
+				budgetLimited = budgetLimited || res.EndReason == defs.EndBudget
+				tooManyErrors = tooManyErrors || res.TooManyErrors
+				uploadDetails = report.NewTransfer(res)
+				uploadTimings, uploadConns = res.Timings, res.Conns
+				writeTimings("Upload", res.Timings)
+				writeTCP("Upload", res.TCP)
+				if res.Bufferbloat() != "" {
+					loadedAdded = append(loadedAdded, res.AddedLatency())
 				}
-				uploadValue = upload
-				bytesWritten = bw
 
-				output.WriteDebug("Upload test finished in %s: %s, %d byte(s) sent\n", time.Since(uploadStart).Round(time.Millisecond), humanizeRate(upload, c), bw)
+				output.WriteDebug("Upload test finished in %s: %s, %d byte(s) sent\n", time.Since(uploadStart).Round(time.Millisecond), r.humanizeRate(res.Mbps), res.Bytes)
+				r.writeStatsDebug("Upload", res)
 			}
 
-			// print result if --simple is given
-			if c.Bool(defs.OptionSimple) {
-				if c.Bool(defs.OptionBytes) {
-					useMebi := c.Bool(defs.OptionMebiBytes)
-					output.WriteOut("Ping:\t%.2f ms\tJitter:\t%.2f ms\nDownload rate:\t%s\nUpload rate:\t%s\n", p, jitter, humanizeMbps(downloadValue, useMebi), humanizeMbps(uploadValue, useMebi))
-				} else {
-					output.WriteOut("Ping:\t%.2f ms\tJitter:\t%.2f ms\nDownload rate:\t%.2f Mbps\nUpload rate:\t%.2f Mbps\n", p, jitter, downloadValue, uploadValue)
-				}
-			}
+			// an interrupted result is incomplete, so it is not shared
+			interrupted := ctx.Err() != nil
 
-			// print share link if --share is given
+			// send telemetry and get a share link if --share is given
 			var shareLink string
-			if telemetryServer.GetLevel() > 0 {
+			if r.opts.Telemetry.GetLevel() > 0 && !interrupted {
 				var extra defs.TelemetryExtra
 				extra.ServerName = currentServer.Name
-				extra.Extra = c.String(defs.OptionTelemetryExtra)
+				extra.Extra = r.opts.TelemetryExtra
 
-				if link, err := sendTelemetry(telemetryServer, ispInfo, downloadValue, uploadValue, p, jitter, currentServer.TLog.String(), extra); err != nil {
+				if link, err := sendTelemetry(r.client, r.opts.Telemetry, ispInfo, downloadValue, uploadValue, p, jitter, currentServer.TLog.String(), extra); err != nil {
 					output.WriteError("Error when sending telemetry data: %s\n", err)
 				} else {
 					shareLink = link
-					// only print to stdout when --json and --csv are not used
-					if !c.Bool(defs.OptionJSON) && !c.Bool(defs.OptionCSV) {
-						if c.Bool(defs.OptionSimple) {
-							output.WriteOut("Share your result: %s\n", link)
-						} else {
-							output.WriteUI("Share your result: %s\n", link)
-						}
-					}
+					output.WriteUI("Share your result: %s\n", link)
 				}
 			}
 
-			// check for --csv or --json. the program prioritize the --csv before the --json. this is the same behavior as speedtest-cli
-			if c.Bool(defs.OptionCSV) {
-				// print csv if --csv is given
-				var rep report.CSVReport
-				rep.Timestamp = time.Now()
-
-				rep.Name = currentServer.Name
-				rep.Address = u.String()
-				rep.Ping = math.Round(p*100) / 100
-				rep.Jitter = math.Round(jitter*100) / 100
-				rep.Download = math.Round(downloadValue*100) / 100
-				rep.Upload = math.Round(uploadValue*100) / 100
-				rep.Share = shareLink
-				rep.IP = ispInfo.IP()
-
-				reps_csv = append(reps_csv, rep)
-			} else if c.Bool(defs.OptionJSON) || c.Bool(defs.OptionJSONStream) {
-				// the stream's final result event carries the same reports
-				// --json prints, so one parser handles both formats
-				var rep report.JSONReport
-				rep.Timestamp = time.Now()
-
-				rep.Ping = math.Round(p*100) / 100
-				rep.Jitter = math.Round(jitter*100) / 100
-				rep.Download = math.Round(downloadValue*100) / 100
-				rep.Upload = math.Round(uploadValue*100) / 100
-				rep.BytesReceived = bytesRead
-				rep.BytesSent = bytesWritten
-				rep.Share = shareLink
-
-				rep.Server.Name = currentServer.Name
-				rep.Server.URL = u.String()
-
-				rep.Client = report.NewClient(ispInfo.RawISPInfo)
-				rep.Client.Readme = ""
-				// IP() falls back to processedString, so the report carries an
-				// address even when the backend keeps rawIspInfo empty.
-				rep.Client.IP = ispInfo.IP()
-
-				reps_json = append(reps_json, rep)
+			var rep report.JSONReport
+			rep.Timestamp = time.Now()
+
+			rep.Ping = math.Round(p*100) / 100
+			rep.Jitter = math.Round(jitter*100) / 100
+			rep.Loss = report.NewLoss(loss)
+			rep.Download = math.Round(downloadValue*100) / 100
+			rep.Upload = math.Round(uploadValue*100) / 100
+			rep.BytesReceived = bytesRead
+			rep.BytesSent = bytesWritten
+			rep.Share = shareLink
+			rep.DurationSeconds = math.Round(time.Since(serverStart).Seconds()*100) / 100
+			rep.Measurement = r.measurement(&currentServer, pingRes, defs.MergeConnInfo(pingRes.Conns, downloadConns, uploadConns), downloadDetails, uploadDetails)
+			rep.PingDetails = report.NewPingDetails(pingRes, r.opts.PingSamples)
+			rep.DownloadDetails = downloadDetails
+			rep.UploadDetails = uploadDetails
+			rep.Timings = report.NewTimings(pingRes.Timings, downloadTimings, uploadTimings)
+			rep.BudgetLimited = budgetLimited
+			rep.TooManyErrors = tooManyErrors
+			rep.Interrupted = interrupted
+			if len(loadedAdded) > 0 {
+				rep.Bufferbloat = defs.BufferbloatGrade(slices.Max(loadedAdded))
+			}
+
+			rep.Server.Name = currentServer.Name
+			rep.Server.URL = u.String()
+
+			rep.Client = report.NewClient(ispInfo.RawISPInfo)
+			rep.Client.Readme = ""
+			// IP() falls back to processedString, so the report carries an
+			// address even when the backend keeps rawIspInfo empty.
+			rep.Client.IP = ispInfo.IP()
+
+			reps = append(reps, rep)
+			if interrupted {
+				return reps, ctx.Err()
 			}
+		} else if ctx.Err() != nil {
+			return reps, ctx.Err()
 		} else {
 			output.WriteUI("Selected server %s (%s) is not responding at the moment, try again later\n", output.Sanitize(currentServer.Name), output.Sanitize(u.Hostname()))
 		}
@@ -225,30 +285,120 @@ output.WriteDebug("IP info: %s\n", output.Sanitize(ispInfo.ProcessedString))
 		}
 	}
 
-	// check for --csv or --json. the program prioritize the --csv before the --json. this is the same behavior as speedtest-cli
-	if c.Bool(defs.OptionCSV) {
-		var buf bytes.Buffer
-		if err := gocsv.MarshalWithoutHeaders(&reps_csv, &buf); err != nil {
-			output.WriteError("Error generating CSV report: %s\n", err)
-		} else {
-			os.Stdout.WriteString(strings.TrimRight(buf.String(), "\n\r") + "\n")
+	return reps, nil
+}
+
+// measurement records how the test against server was run, with the streams
+// the download and upload, when they ran, actually used
+func (r *Runner) measurement(server *defs.Server, ping *defs.PingResult, conns *defs.ConnInfo, download, upload *report.Transfer) *report.Measurement {
+	m := &report.Measurement{
+		ServerID:     server.ID,
+		Sponsor:      server.Sponsor(),
+		PingMethod:   ping.Method,
+		PingFallback: ping.Method != defs.MethodICMP && !r.noICMP,
+		PingCount:    len(ping.Samples),
+		PingAddress:  ping.Addr,
+		Addresses:    conns.Remotes,
+		Family:       conns.Family(),
+		TLSVersion:   conns.TLSVersion,
+		TLSCipher:    conns.TLSCipher,
+		Adaptive:     r.opts.Adaptive,
+		Chunks:       r.opts.Chunks,
+		UploadSize:   r.opts.UploadSize,
+	}
+	if r.opts.Adaptive {
+		m.MaxStreams = r.opts.MaxConcurrent
+	}
+	if download != nil {
+		m.DownloadStreams = download.Streams
+	}
+	if upload != nil {
+		m.UploadStreams = upload.Streams
+	}
+	return m
+}
+
+// writeTimings prints the median time the requests of a phase spent on each
+// step, leaving out the steps none of them took
+func writeTimings(label string, t *defs.ConnTimings) {
+	if t == nil || t.Requests == 0 {
+		return
+	}
+	var steps []string
+	for _, step := range []struct {
+		name string
+		vals []float64
+	}{
+		{"DNS", t.DNS},
+		{"connect", t.Connect},
+		{"TLS", t.TLS},
+		{"first byte", t.TTFB},
+	} {
+		if len(step.vals) > 0 {
+			steps = append(steps, fmt.Sprintf("%s %.2f ms", step.name, defs.NewLatencyStats(step.vals).Median))
 		}
-	} else if c.Bool(defs.OptionJSON) {
-		if b, err := json.Marshal(&reps_json); err != nil {
-			output.WriteError("Error generating JSON report: %s\n", err)
-		} else {
-			os.Stdout.Write(b[:])
-			os.Stdout.WriteString("\n")
+	}
+	output.WriteUI("%s timings:\t%s (median of %d request(s), %d new connection(s))\n", label, strings.Join(steps, ", "), t.Requests, t.NewConns)
+	output.WriteDebug("%s timings: DNS %v, connect %v, TLS %v, first byte %v\n", label, t.DNS, t.Connect, t.TLS, t.TTFB)
+}
+
+// writeTCP prints what TCP_INFO said about the connections of a phase, where
+// it could be read, and each connection's under --debug
+func writeTCP(label string, streams []defs.TCPStats) {
+	if len(streams) == 0 {
+		return
+	}
+	total := defs.AggregateTCPStats(streams)
+	var ratio float64
+	if total.SegmentsOut > 0 {
+		ratio = float64(total.Retransmits) * 100 / float64(total.SegmentsOut)
+	}
+	output.WriteUI("%s TCP:\t%d retransmit(s) (%.2f%%), %d out of order, RTT %.2f ms, cwnd %d, receive window %d KiB (%d connection(s))\n",
+		label, total.Retransmits, ratio, total.OutOfOrder, total.RTT, total.Cwnd, total.RcvSpace/1024, len(streams))
+	for _, s := range streams {
+		output.WriteDebug("%s TCP %s: %d of %d segment(s) retransmitted, %d out of order, RTT %.2f ms, cwnd %d, delivery rate %.2f Mbps, receive window %d bytes\n",
+			label, s.Local, s.Retransmits, s.SegmentsOut, s.OutOfOrder, s.RTT, s.Cwnd, s.DeliveryRate, s.RcvSpace)
+	}
+}
+
+// measureLoss runs the packet loss test against server. It returns nil when
+// the test failed or was interrupted.
+func (r *Runner) measureLoss(ctx context.Context, server *defs.Server, silent bool) *defs.Loss {
+	output.WriteDebug("Packet loss test starting: %d probes, %s apart\n", r.opts.LossCount, r.opts.LossInterval)
+	output.WriteEvent(output.PhaseEvent{Event: "phase", Phase: "loss"})
+	lossStart := time.Now()
+
+	var pb *spinner.Spinner
+	if !silent {
+		pb = spinner.New(spinner.CharSets[11], 100*time.Millisecond, spinner.WithWriterFile(os.Stderr))
+		pb.Prefix = "Measuring packet loss...  "
+		pb.Start()
+	}
+
+	loss, err := server.PacketLoss(ctx, defs.LossOptions{
+		Count:    r.opts.LossCount,
+		Interval: r.opts.LossInterval,
+		Timeout:  lossTimeout,
+		Source:   r.opts.Source,
+		Network:  r.network,
+	})
+	if pb != nil {
+		pb.Stop()
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			output.WriteError("Failed to measure packet loss: %s\n", err)
 		}
-	} else if c.Bool(defs.OptionJSONStream) {
-		output.WriteEvent(output.ResultEvent{Event: "result", Reports: reps_json})
+		return nil
 	}
 
-	return nil
+	output.WriteDebug("Packet loss test finished in %s: %d/%d replies over %s\n", time.Since(lossStart).Round(time.Millisecond), loss.Received, loss.Sent, loss.Method)
+	output.WriteUI("Packet loss: %.2f%% (%d/%d %s probes answered)\n", loss.Percent(), loss.Received, loss.Sent, strings.ToUpper(loss.Method))
+	return loss
 }
 
 // sendTelemetry sends the telemetry result to server, if --share is given
-func sendTelemetry(telemetryServer defs.TelemetryServer, ispInfo *defs.GetIPResult, download, upload, pingVal, jitter float64, logs string, extra defs.TelemetryExtra) (string, error) {
+func sendTelemetry(client *http.Client, telemetryServer defs.TelemetryServer, ispInfo *defs.GetIPResult, download, upload, pingVal, jitter float64, logs string, extra defs.TelemetryExtra) (string, error) {
 	var buf bytes.Buffer
 	wr := multipart.NewWriter(&buf)
 
@@ -328,7 +478,7 @@ func sendTelemetry(telemetryServer defs.TelemetryServer, ispInfo *defs.GetIPResu
 	req.Header.Set("Content-Type", wr.FormDataContentType())
 	req.Header.Set("User-Agent", defs.UserAgent)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		output.WriteDebug("Error when making HTTP request: %s\n", err)
 		return "", err
@@ -360,13 +510,73 @@ func sendTelemetry(telemetryServer defs.TelemetryServer, ispInfo *defs.GetIPResu
 // humanizeRate formats a rate the same way the run's own result will be
 // reported, so a debug line cannot appear to contradict the JSON, CSV or
 // --simple output sitting next to it.
-func humanizeRate(mbps float64, c *cli.Context) string {
-	if c.Bool(defs.OptionBytes) {
-		return humanizeMbps(mbps, c.Bool(defs.OptionMebiBytes))
+func (r *Runner) humanizeRate(mbps float64) string {
+	if r.opts.Bytes {
+		return humanizeMbps(mbps, r.opts.MebiBytes)
 	}
 	return fmt.Sprintf("%.2f Mbps", mbps)
 }
 
+// phaseBudget returns how many bytes the next download or upload may move
+// after `spent` so far this run, zero meaning no limit. It returns false when
+// the run's budget is already spent and the phase should not run at all.
+func (r *Runner) phaseBudget(spent uint64) (uint64, bool) {
+	limit := r.opts.MaxPhaseBytes
+	if r.opts.MaxBytes > 0 {
+		if spent >= r.opts.MaxBytes {
+			return 0, false
+		}
+		if left := r.opts.MaxBytes - spent; limit == 0 || left < limit {
+			limit = left
+		}
+	}
+	return limit, true
+}
+
+// transferOptions builds the options the download and upload tests share
+func (r *Runner) transferOptions(silent bool) defs.TransferOptions {
+	return defs.TransferOptions{
+		Silent:            silent,
+		UseBytes:          r.opts.Bytes,
+		UseMebi:           r.opts.MebiBytes,
+		Requests:          r.opts.Concurrent,
+		Adaptive:          r.opts.Adaptive,
+		MaxRequests:       r.opts.MaxConcurrent,
+		Duration:          r.opts.Duration,
+		Warmup:            r.opts.Warmup,
+		AutoWarmup:        r.opts.AutoWarmup,
+		LoadedLatency:     r.opts.LoadedLatency,
+		ConvergeWindow:    r.opts.ConvergeWindow,
+		ConvergeTolerance: r.opts.ConvergeTolerance,
+		MaxErrorRatio:     r.opts.MaxErrorRatio,
+		Chunks:            r.opts.Chunks,
+		UploadSize:        r.opts.UploadSize,
+		NoPreAllocate:     r.opts.NoPreAllocate,
+	}
+}
+
+// streamsDebug describes how many streams a transfer will use
+func (r *Runner) streamsDebug() string {
+	if r.opts.Adaptive {
+		return fmt.Sprintf("adaptive up to %d stream(s)", r.opts.MaxConcurrent)
+	}
+	return fmt.Sprintf("%d stream(s)", r.opts.Concurrent)
+}
+
+// writeStatsDebug reports how steady a transfer was under --debug
+func (r *Runner) writeStatsDebug(phase string, res *defs.TransferResult) {
+	stats := res.Stats()
+	if res.Warmup > 0 {
+		output.WriteDebug("%s warm-up: first %s left out, %s including it\n", phase, res.Warmup.Round(time.Millisecond), r.humanizeRate(res.RawMbps))
+	}
+	if grade := res.Bufferbloat(); grade != "" {
+		output.WriteDebug("%s latency over %d ping(s): %.2f ms idle, %.2f ms loaded, bufferbloat grade %s\n", phase, len(res.LoadedPings), res.IdlePing, res.LoadedPing(), grade)
+	}
+	output.WriteDebug("%s rate over %d sample(s): min %s, median %s, p90 %s, max %s, CV %.2f\n",
+		phase, len(res.Samples)-res.WarmupSamples, r.humanizeRate(stats.Min), r.humanizeRate(stats.Median),
+		r.humanizeRate(stats.P90), r.humanizeRate(stats.Max), stats.CV)
+}
+
 func humanizeMbps(mbps float64, useMebi bool) string {
 	val := mbps / 8
 	var base float64 = 1000
@@ -0,0 +1,151 @@
+package speedtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/history"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// History lists and summarises the runs --save-history recorded
+func History(c *cli.Context) error {
+	now := time.Now()
+	var f history.Filter
+	var err error
+	if f.Since, err = parseWhen(c.String(defs.OptionSince), now); err != nil {
+		return err
+	}
+	if f.Until, err = parseWhen(c.String(defs.OptionUntil), now); err != nil {
+		return err
+	}
+	f.Server = c.String(defs.OptionMatch)
+
+	store, err := openHistory(c)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	reps, err := store.Results(f)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool(defs.OptionSummary) {
+		summary := history.Summarize(reps, time.Local)
+		if c.Bool(defs.OptionJSON) {
+			return json.NewEncoder(os.Stdout).Encode(summary)
+		}
+		writeSummary(summary)
+		return nil
+	}
+
+	switch {
+	case c.Bool(defs.OptionCSV):
+		gocsv.TagSeparator = c.String(defs.OptionCSVDelimiter)
+		reps_csv := make([]report.CSVReport, 0, len(reps))
+		for _, rep := range reps {
+			reps_csv = append(reps_csv, report.NewCSVReport(rep))
+		}
+		return gocsv.Marshal(&reps_csv, os.Stdout)
+	case c.Bool(defs.OptionJSON):
+		// an empty history still prints a JSON array, not null
+		if reps == nil {
+			reps = []report.JSONReport{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(reps)
+	}
+
+	if len(reps) == 0 {
+		output.WriteOut("No runs recorded in this period\n")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Time\tServer\tPing ms\tJitter ms\tDownload Mbps\tUpload Mbps\t\n")
+	for _, rep := range reps {
+		fmt.Fprintf(w, "%s\t%s\t%.2f\t%.2f\t%.2f\t%.2f\t\n", rep.Timestamp.Local().Format(time.DateTime),
+			output.Sanitize(rep.Server.Name), rep.Ping, rep.Jitter, rep.Download, rep.Upload)
+	}
+	w.Flush()
+	output.WriteOut("\n")
+	writeSummary(history.Summarize(reps, time.Local))
+	return nil
+}
+
+// writeSummary prints a summary for people to read
+func writeSummary(s history.Summary) {
+	if s.Runs == 0 {
+		output.WriteOut("No runs recorded in this period\n")
+		return
+	}
+	output.WriteOut("%d runs from %s to %s\n\n", s.Runs, s.From.Local().Format(time.DateTime), s.To.Local().Format(time.DateTime))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "\tMean\tMin\tMedian\tP90\tMax\t\n")
+	for _, row := range []struct {
+		name  string
+		stats history.Stats
+	}{
+		{"Download Mbps", s.Download},
+		{"Upload Mbps", s.Upload},
+		{"Ping ms", s.Ping},
+		{"Jitter ms", s.Jitter},
+	} {
+		st := row.stats
+		fmt.Fprintf(w, "%s\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", row.name, st.Mean, st.Min, st.Median, st.P90, st.Max)
+	}
+	w.Flush()
+
+	output.WriteOut("\nSlowest days:\n")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, d := range s.WorstDays {
+		fmt.Fprintf(w, "%s\t%.2f Mbps down\t%.2f Mbps up\t%.2f ms ping\t%d runs\t\n", d.Date, d.Download, d.Upload, d.Ping, d.Runs)
+	}
+	w.Flush()
+}
+
+// openHistory opens the history --history-file names, or the default one
+func openHistory(c *cli.Context) (*history.Store, error) {
+	path := c.String(defs.OptionHistoryFile)
+	if path == "" {
+		var err error
+		if path, err = history.DefaultPath(); err != nil {
+			return nil, fmt.Errorf("cannot work out where to keep the history, give --%s: %w", defs.OptionHistoryFile, err)
+		}
+	}
+	return history.Open(path)
+}
+
+// parseWhen parses a point in time: a date, an RFC 3339 time, or how long ago
+// as a duration, which may count days as in "30d". Empty is the zero time.
+func parseWhen(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: give a date (2006-01-02), a time (2006-01-02T15:04:05Z) or how long ago (30d, 12h)", s)
+}
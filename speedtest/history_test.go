@@ -0,0 +1,39 @@
+package speedtest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	now := time.Date(2024, time.March, 31, 12, 0, 0, 0, time.Local)
+
+	cases := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"", time.Time{}, false},
+		{"30d", time.Date(2024, time.March, 1, 12, 0, 0, 0, time.Local), false},
+		{"0d", now, false},
+		{"12h", now.Add(-12 * time.Hour), false},
+		{"2024-03-15", time.Date(2024, time.March, 15, 0, 0, 0, 0, time.Local), false},
+		{"2024-03-15T08:00:00Z", time.Date(2024, time.March, 15, 8, 0, 0, 0, time.UTC), false},
+		{"-1d", time.Time{}, true},
+		{"-12h", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+		{"2024-13-01", time.Time{}, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			got, err := parseWhen(c.in, now)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("parseWhen(%q) error = %v, want error %t", c.in, err, c.wantErr)
+			}
+			if !got.Equal(c.want) {
+				t.Errorf("parseWhen(%q) = %s, want %s", c.in, got, c.want)
+			}
+		})
+	}
+}
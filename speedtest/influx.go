@@ -0,0 +1,121 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+const (
+	// influxAttempts is how many times a write is tried before giving up
+	influxAttempts = 3
+	// influxBackoff is the wait before the first retry, doubled for each
+	// one after it
+	influxBackoff = time.Second
+	// influxTimeout bounds each attempt
+	influxTimeout = 10 * time.Second
+)
+
+// influxWriter writes reports to an InfluxDB v2 /api/v2/write endpoint
+type influxWriter struct {
+	url     string
+	token   string
+	client  *http.Client
+	backoff time.Duration
+}
+
+// newInfluxWriter checks the settings for writing to the InfluxDB at baseURL.
+// baseURL is the server's root; a URL already ending in /api/v2/write is
+// taken as it is.
+func newInfluxWriter(baseURL, org, bucket, token string) (*influxWriter, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL %q", baseURL)
+	}
+	if org == "" || bucket == "" {
+		return nil, fmt.Errorf("writing to InfluxDB needs both an organization and a bucket")
+	}
+
+	if !strings.HasSuffix(strings.TrimRight(u.Path, "/"), "/api/v2/write") {
+		u = u.JoinPath("api", "v2", "write")
+	}
+	q := u.Query()
+	q.Set("org", org)
+	q.Set("bucket", bucket)
+	q.Set("precision", "ns")
+	u.RawQuery = q.Encode()
+
+	return &influxWriter{
+		url:     u.String(),
+		token:   token,
+		client:  &http.Client{Timeout: influxTimeout},
+		backoff: influxBackoff,
+	}, nil
+}
+
+// write sends the reports, retrying a failed attempt when the failure may be
+// temporary: a network error, a 429 or a 5xx. Any other status is the
+// request's fault and is not retried.
+func (w *influxWriter) write(ctx context.Context, reps []report.JSONReport) error {
+	if len(reps) == 0 {
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := report.WriteInflux(&body, reps); err != nil {
+		return err
+	}
+
+	backoff := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, body.Bytes())
+		if err == nil || !retry || attempt == influxAttempts {
+			return err
+		}
+
+		output.WriteDebug("Writing to InfluxDB failed, retrying in %s: %s\n", backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one attempt at a write, and says whether a failed one is worth
+// retrying
+func (w *influxWriter) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	// InfluxDB explains a refused write in the body
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("InfluxDB answered %s: %s", resp.Status, output.Sanitize(strings.TrimSpace(string(msg))))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
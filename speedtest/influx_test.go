@@ -0,0 +1,104 @@
+package speedtest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+func TestNewInfluxWriter(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		org     string
+		bucket  string
+		want    string
+		wantErr bool
+	}{
+		{"root", "http://influx:8086", "home", "speed", "http://influx:8086/api/v2/write?bucket=speed&org=home&precision=ns", false},
+		{"trailing slash", "http://influx:8086/", "home", "speed", "http://influx:8086/api/v2/write?bucket=speed&org=home&precision=ns", false},
+		{"behind a proxy", "https://example.com/influx", "my org", "speed", "https://example.com/influx/api/v2/write?bucket=speed&org=my+org&precision=ns", false},
+		{"full path", "http://influx:8086/api/v2/write", "home", "speed", "http://influx:8086/api/v2/write?bucket=speed&org=home&precision=ns", false},
+		{"no scheme", "influx:8086", "home", "speed", "", true},
+		{"no org", "http://influx:8086", "", "speed", "", true},
+		{"no bucket", "http://influx:8086", "home", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := newInfluxWriter(tt.url, tt.org, tt.bucket, "")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("newInfluxWriter(%q) = %q, want an error", tt.url, w.url)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newInfluxWriter(%q): %v", tt.url, err)
+			}
+			if w.url != tt.want {
+				t.Errorf("url = %q, want %q", w.url, tt.want)
+			}
+		})
+	}
+}
+
+func TestInfluxWriterRetries(t *testing.T) {
+	reps := []report.JSONReport{{Timestamp: time.Unix(1700000000, 0), Server: report.Server{Name: "A"}, Download: 93.5}}
+
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"accepted", []int{http.StatusNoContent}, false, 1},
+		{"recovers", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusNoContent}, false, 3},
+		{"gives up", []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusNoContent}, true, influxAttempts},
+		{"refused", []int{http.StatusUnauthorized, http.StatusNoContent}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := attempts.Add(1)
+				if got := r.Header.Get("Authorization"); got != "Token secret" {
+					t.Errorf("Authorization = %q", got)
+				}
+				body, _ := io.ReadAll(r.Body)
+				if !strings.HasPrefix(string(body), "librespeed,server=A ping=0,jitter=0,download=93.5,") {
+					t.Errorf("body = %q", body)
+				}
+				status := tt.statuses[n-1]
+				if status != http.StatusNoContent {
+					http.Error(w, `{"code":"nope"}`, status)
+					return
+				}
+				w.WriteHeader(status)
+			}))
+			defer srv.Close()
+
+			w, err := newInfluxWriter(srv.URL, "home", "speed", "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.backoff = time.Millisecond
+
+			err = w.write(context.Background(), reps)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("write: %v, want error %t", err, tt.wantErr)
+			}
+			if got := int(attempts.Load()); got != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
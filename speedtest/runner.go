@@ -0,0 +1,509 @@
+package speedtest
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// ErrNoServer is returned when none of the servers to test could be reached
+var ErrNoServer = errors.New("no server is currently available, please try again later")
+
+// Scheme selects how the URL scheme of every test server is rewritten.
+type Scheme int
+
+const (
+	// SchemeAsListed keeps the scheme the server list gives, and uses http
+	// for servers listed without one.
+	SchemeAsListed Scheme = iota
+	// SchemeHTTPS forces https for every test server.
+	SchemeHTTPS
+	// SchemeHTTP forces http for every test server.
+	SchemeHTTP
+)
+
+// Options configures a Runner. Start from DefaultOptions: a zero stream
+// count or duration is rejected rather than guessed at.
+type Options struct {
+	// ServerListURL is where the server list is fetched from when Servers is
+	// empty. On failure /.well-known/librespeed at its origin is tried.
+	ServerListURL string
+	// Servers is used as the server list instead of fetching one.
+	Servers []defs.Server
+	// ServerIDs selects servers from the list, and every one of them is
+	// tested; -1 tests the whole list. When empty, the server with the lowest
+	// ping is selected and tested alone.
+	ServerIDs []int
+	// ExcludeIDs removes servers from the list before selection. Cannot be
+	// used together with ServerIDs.
+	ExcludeIDs []int
+	// ServerListTTL lets a Runner that runs more than once keep the server
+	// list, and the server selected from it, for this long. A run that
+	// cannot test any of them drops them early. Zero fetches and selects
+	// anew for every run.
+	ServerListTTL time.Duration
+	// ForceScheme rewrites the scheme of every test server. It does not
+	// affect how the server list itself is fetched.
+	ForceScheme Scheme
+
+	ForceIPv4 bool
+	ForceIPv6 bool
+	// Source is the IP address to bind to. Cannot be used with Interface.
+	Source string
+	// Interface is the network interface to bind to. Implies NoICMP.
+	Interface string
+	// Fwmark is the firewall mark set on every socket. Implies NoICMP.
+	Fwmark int
+	// Timeout bounds every HTTP request.
+	Timeout time.Duration
+	// CACert is a PEM bundle file used instead of the system trust store.
+	CACert         string
+	SkipCertVerify bool
+
+	// NoICMP measures ping over HTTP rather than ICMP.
+	NoICMP     bool
+	NoDownload bool
+	NoUpload   bool
+	// PingCount is how many pings the ping and jitter are worked out from.
+	// PingSamples keeps every round-trip time in the report.
+	PingCount   int
+	PingSamples bool
+	// Concurrent is the number of requests kept in flight during a transfer.
+	Concurrent int
+	// Adaptive starts each transfer with one request in flight and adds
+	// more while each still raises the rate, up to MaxConcurrent, instead of
+	// using Concurrent.
+	Adaptive      bool
+	MaxConcurrent int
+	// Duration is how long each of the download and upload phases runs.
+	Duration time.Duration
+	// Warmup is left out of the start of each download and upload, so
+	// connection setup and TCP slow start do not drag the rate down. The
+	// rate including it is reported alongside. AutoWarmup leaves out however
+	// long the rate takes to stop climbing instead.
+	Warmup     time.Duration
+	AutoWarmup bool
+	// LoadedLatency keeps pinging the server while the download and upload
+	// saturate the link, and reports how much latency that added.
+	LoadedLatency bool
+	// LossCount sends a burst of this many probes after the ping test,
+	// LossInterval apart, and reports how many went unanswered. Zero skips
+	// the packet loss test.
+	LossCount    int
+	LossInterval time.Duration
+	// ConvergeWindow ends each download and upload early once the rate has
+	// stayed within ConvergeTolerance (a fraction of it) for this long;
+	// Duration remains the upper bound. Zero always runs the full Duration.
+	ConvergeWindow    time.Duration
+	ConvergeTolerance float64
+	// MaxBytes caps the data the downloads and uploads of a whole run may
+	// move, across every server tested; MaxPhaseBytes caps each download and
+	// upload on its own. A phase is stopped once it reaches its cap, and
+	// skipped once the run's is spent. Zero is no limit.
+	MaxBytes      uint64
+	MaxPhaseBytes uint64
+	// MaxErrorRatio is the share of failed requests, between 0 and 1, above
+	// which a download or upload is reported as having too many errors
+	MaxErrorRatio float64
+	// Chunks is the number of chunks requested per download request. The
+	// chunk size depends on the server.
+	Chunks int
+	// UploadSize is the size of each upload request in KiB.
+	UploadSize    int
+	NoPreAllocate bool
+
+	// DistanceUnit is passed to the server's getIP endpoint: "km", "mi" or
+	// "NM".
+	DistanceUnit string
+	// Bytes and MebiBytes only change how rates are displayed while the test
+	// runs; reports are always in Mbps.
+	Bytes     bool
+	MebiBytes bool
+
+	// Telemetry is where results are shared. Nothing is sent while its level
+	// is empty or "disabled".
+	Telemetry      defs.TelemetryServer
+	TelemetryExtra string
+}
+
+// DefaultOptions returns the options the command line uses when no flags are
+// given.
+func DefaultOptions() Options {
+	return Options{
+		ServerListURL:     serverListUrl,
+		PingCount:         pingCount,
+		Timeout:           15 * time.Second,
+		Concurrent:        3,
+		MaxConcurrent:     16,
+		Duration:          15 * time.Second,
+		ConvergeTolerance: 0.05,
+		LossInterval:      100 * time.Millisecond,
+		MaxErrorRatio:     0.1,
+		Chunks:            100,
+		UploadSize:        1024,
+		DistanceUnit:      "km",
+	}
+}
+
+// Runner runs speed tests with a fixed set of options. Progress and results
+// are written through the output package like the rest of the program; set
+// output.SetQuiet to keep a Runner from printing anything.
+type Runner struct {
+	opts    Options
+	network string
+	noICMP  bool
+	// client carries the runner's timeout, TLS settings and bound dialer.
+	// It is handed to every server the runner tests, so runners with
+	// different settings can share a process without stepping on each other.
+	client *http.Client
+	// spent is the data a run moved before this runner's, which its
+	// MaxBytes also covers: a dual-stack run tests each family with a
+	// runner of its own
+	spent uint64
+
+	// mu guards the servers kept between runs, see ServerListTTL
+	mu        sync.Mutex
+	servers   []defs.Server
+	serversAt time.Time
+}
+
+// NewRunner validates opts and prepares the HTTP client the tests will use.
+func NewRunner(opts Options) (*Runner, error) {
+	if opts.Concurrent <= 0 {
+		return nil, fmt.Errorf("concurrent requests cannot be lower than 1: %d is given", opts.Concurrent)
+	}
+	if opts.Adaptive && opts.MaxConcurrent <= 0 {
+		return nil, fmt.Errorf("maximum concurrent requests cannot be lower than 1: %d is given", opts.MaxConcurrent)
+	}
+	if opts.Duration <= 0 {
+		return nil, errors.New("invalid test duration")
+	}
+	if opts.Warmup < 0 || opts.Warmup >= opts.Duration {
+		return nil, errors.New("warm-up must be shorter than the test duration")
+	}
+	if opts.Warmup > 0 && opts.AutoWarmup {
+		return nil, errors.New("options Warmup and AutoWarmup cannot both be set")
+	}
+	if opts.ConvergeWindow < 0 || opts.ConvergeWindow >= opts.Duration {
+		return nil, errors.New("convergence window must be shorter than the test duration")
+	}
+	// convergence is only judged on the rate after the warm-up
+	if opts.ConvergeWindow > 0 && opts.Warmup+opts.ConvergeWindow >= opts.Duration {
+		return nil, errors.New("warm-up and convergence window together must be shorter than the test duration")
+	}
+	if opts.ConvergeWindow > 0 && opts.ConvergeTolerance <= 0 {
+		return nil, fmt.Errorf("convergence tolerance must be above 0: %g is given", opts.ConvergeTolerance)
+	}
+	if opts.MaxErrorRatio < 0 || opts.MaxErrorRatio > 1 {
+		return nil, fmt.Errorf("error ratio must be between 0 and 1: %g is given", opts.MaxErrorRatio)
+	}
+	if opts.Source != "" && opts.Interface != "" {
+		return nil, errors.New("options Source and Interface cannot both be set")
+	}
+	if len(opts.ExcludeIDs) > 0 && len(opts.ServerIDs) > 0 {
+		return nil, errors.New("options ExcludeIDs and ServerIDs cannot both be set")
+	}
+	if opts.PingCount < 1 {
+		return nil, fmt.Errorf("pings cannot be fewer than 1: %d is given", opts.PingCount)
+	}
+	if opts.LossCount < 0 {
+		return nil, fmt.Errorf("packet loss probes cannot be fewer than 0: %d is given", opts.LossCount)
+	}
+	if opts.LossCount > 0 && opts.LossInterval <= 0 {
+		return nil, errors.New("invalid packet loss probe interval")
+	}
+	if opts.ServerListTTL < 0 {
+		return nil, errors.New("invalid server list TTL")
+	}
+
+	r := &Runner{opts: opts, noICMP: opts.NoICMP}
+
+	switch {
+	case opts.ForceIPv4:
+		r.network = "ip4"
+	case opts.ForceIPv6:
+		r.network = "ip6"
+	default:
+		r.network = "ip"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// tune connection pool for concurrent speed tests
+	streams := opts.Concurrent
+	if opts.Adaptive {
+		streams = opts.MaxConcurrent
+	}
+	transport.MaxIdleConnsPerHost = streams + 2
+	transport.MaxConnsPerHost = streams + 2
+
+	if opts.CACert != "" {
+		caCert, err := os.ReadFile(opts.CACert)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: opts.SkipCertVerify,
+			RootCAs:            caCertPool,
+		}
+	} else {
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: opts.SkipCertVerify,
+		}
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	// bind to source IP address if given
+	if opts.Source != "" {
+		var err error
+		dialer, err = newDialerAddressBound(opts.Source, r.network)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// bind to interface if given
+	if opts.Interface != "" || opts.Fwmark > 0 {
+		var err error
+		dialer, err = newDialerInterfaceOrFwmarkBound(opts.Interface, opts.Fwmark)
+		if err != nil {
+			return nil, err
+		}
+		// ICMP ping does not support interface binding.
+		r.noICMP = true
+	}
+
+	// enforce if ipv4/ipv6 is forced
+	var dialContext func(context.Context, string, string) (net.Conn, error)
+	switch {
+	case opts.ForceIPv4:
+		dialContext = func(ctx context.Context, network, address string) (conn net.Conn, err error) {
+			return dialer.DialContext(ctx, "tcp4", address)
+		}
+	case opts.ForceIPv6:
+		dialContext = func(ctx context.Context, network, address string) (conn net.Conn, err error) {
+			return dialer.DialContext(ctx, "tcp6", address)
+		}
+	default:
+		dialContext = dialer.DialContext
+	}
+
+	// use a transport that binds the source address
+	// this is modified from http.DefaultTransport
+	transport.DialContext = dialContext
+	r.client = &http.Client{
+		Timeout:   opts.Timeout,
+		Transport: transport,
+	}
+
+	return r, nil
+}
+
+// ServerList returns every server in the configured list, with the scheme
+// forced as configured but without ServerIDs or ExcludeIDs applied.
+func (r *Runner) ServerList() ([]defs.Server, error) {
+	return r.loadServers(context.Background(), false)
+}
+
+// Run tests the configured servers and returns one report for each server
+// that was up. Servers that do not respond are skipped; any other failure
+// ends the run. Cancelling ctx ends it too, but the reports measured so far
+// are returned alongside ctx's error, the last one marked as interrupted.
+func (r *Runner) Run(ctx context.Context) ([]report.JSONReport, error) {
+	servers, err := r.testServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reps, err := r.doSpeedTest(ctx, servers)
+	// a server that could not be tested may be gone for good: fetch and
+	// select again next time rather than keep trying it
+	if len(reps) == 0 || (err != nil && ctx.Err() == nil) {
+		r.mu.Lock()
+		r.servers = nil
+		r.mu.Unlock()
+	}
+	return reps, err
+}
+
+// testServers returns the servers a run tests: the ones kept from an earlier
+// run while ServerListTTL allows, or else freshly fetched and selected.
+// Cancelling ctx stops the fetching and selecting, and returns its error.
+func (r *Runner) testServers(ctx context.Context) ([]defs.Server, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.servers != nil && time.Since(r.serversAt) < r.opts.ServerListTTL {
+		output.WriteDebug("Reusing the server list fetched at %s\n", r.serversAt.Format(time.TimeOnly))
+		return r.servers, nil
+	}
+
+	servers, err := r.loadServers(ctx, true)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		output.WriteError("Error when fetching server list: %s\n", err)
+		return nil, err
+	}
+
+	// if servers are given, do speed tests with all of them
+	if len(r.opts.ServerIDs) == 0 {
+		// else select the fastest server from the list
+		output.WriteUI("Selecting the fastest server based on ping\n")
+		server, err := r.selectFastest(ctx, servers)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, err
+		}
+		servers = []defs.Server{server}
+	}
+
+	if r.opts.ServerListTTL > 0 {
+		r.servers, r.serversAt = servers, time.Now()
+	}
+	return servers, nil
+}
+
+// loadServers fetches or copies the server list and preprocesses it
+func (r *Runner) loadServers(ctx context.Context, filter bool) ([]defs.Server, error) {
+	var servers []defs.Server
+	if len(r.opts.Servers) > 0 {
+		// preprocessServers rewrites in place, so the caller's list is left alone
+		servers = append(servers, r.opts.Servers...)
+	} else {
+		// fetch the server list JSON and parse it into the `servers` array
+		serverUrl := r.opts.ServerListURL
+		if serverUrl == "" {
+			serverUrl = serverListUrl
+		}
+		output.WriteUI("Retrieving server list from %s\n", serverUrl)
+
+		var err error
+		servers, err = getServerList(ctx, r.client, serverUrl)
+		if err != nil && ctx.Err() == nil {
+			output.WriteUI("Retry with /.well-known/librespeed\n")
+			servers, err = getServerList(ctx, r.client, wellKnownServerURL(serverUrl))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	servers, err := preprocessServers(servers, r.opts.ForceScheme, r.opts.ExcludeIDs, r.opts.ServerIDs, filter)
+	if err != nil {
+		return nil, err
+	}
+	for i := range servers {
+		servers[i].Client = r.client
+	}
+	return servers, nil
+}
+
+// selectFastest pings every server and returns the one with the lowest ping
+func (r *Runner) selectFastest(ctx context.Context, servers []defs.Server) (defs.Server, error) {
+	var wg sync.WaitGroup
+	jobs := make(chan PingJob, len(servers))
+	results := make(chan PingResult, len(servers))
+	done := make(chan struct{})
+
+	pingList := make(map[int]float64)
+
+	// spawn 10 concurrent pingers
+	for i := 0; i < 10; i++ {
+		go r.pingWorker(ctx, jobs, results, &wg)
+	}
+
+	// send ping jobs to workers
+	for idx, server := range servers {
+		wg.Add(1)
+		jobs <- PingJob{Index: idx, Server: server}
+	}
+	close(jobs)
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+Loop:
+	for {
+		select {
+		case result := <-results:
+			pingList[result.Index] = result.Ping
+		case <-done:
+			break Loop
+		}
+	}
+
+	if len(pingList) == 0 {
+		return defs.Server{}, ErrNoServer
+	}
+
+	// get the fastest server's index in the `servers` array
+	var serverIdx int
+	for idx, newPing := range pingList {
+		oldPing, ok := pingList[serverIdx]
+
+		if ok {
+			if newPing > 0 && newPing <= oldPing {
+				serverIdx = idx
+			}
+		} else {
+			serverIdx = idx
+		}
+	}
+
+	return servers[serverIdx], nil
+}
+
+func (r *Runner) pingWorker(ctx context.Context, jobs <-chan PingJob, results chan<- PingResult, wg *sync.WaitGroup) {
+	for job := range jobs {
+		server := job.Server
+		// get the URL of the speed test server from the JSON
+		u, err := server.GetURL()
+		if err != nil {
+			output.WriteDebug("Server URL is invalid for %s (%s), skipping\n", output.Sanitize(server.Name), output.Sanitize(server.Server))
+			wg.Done()
+			continue
+		}
+
+		// check the server is up by accessing the ping URL and checking its returned value == empty and status code == 200
+		if server.IsUpContext(ctx) {
+			// skip ICMP if option given
+			server.NoICMP = r.noICMP
+
+			// if server is up, get ping
+			res, err := server.ICMPPing(ctx, 1, r.opts.Source, r.network)
+			if err != nil {
+				output.WriteDebug("Can't ping server %s (%s), skipping\n", output.Sanitize(server.Name), output.Sanitize(u.Hostname()))
+				wg.Done()
+				continue
+			}
+			// return result
+			results <- PingResult{Index: job.Index, Ping: res.Ping}
+			wg.Done()
+		} else {
+			output.WriteDebug("Server %s (%s) doesn't seem to be up, skipping\n", output.Sanitize(server.Name), output.Sanitize(u.Hostname()))
+			wg.Done()
+		}
+	}
+}
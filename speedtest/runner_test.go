@@ -0,0 +1,331 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+// newTestBackend serves the endpoints a LibreSpeed backend exposes, just well
+// enough for a run to complete against it.
+func newTestBackend(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	})
+	mux.HandleFunc("/garbage", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<20))
+	})
+	mux.HandleFunc("/getIP", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"processedString":"192.0.2.1 - Example ISP","rawIspInfo":""}`))
+	})
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestRunnerRunAgainstBackend(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	ts := newTestBackend(t)
+
+	opts := DefaultOptions()
+	opts.Servers = []defs.Server{{
+		ID:          1,
+		Name:        "test",
+		Server:      ts.URL,
+		DownloadURL: "garbage",
+		UploadURL:   "empty",
+		PingURL:     "empty",
+		GetIPURL:    "getIP",
+	}}
+	opts.ServerIDs = []int{1}
+	opts.NoICMP = true
+	opts.Concurrent = 1
+	opts.Duration = 300 * time.Millisecond
+	opts.UploadSize = 64
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+
+	reps, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(reps) != 1 {
+		t.Fatalf("got %d reports, want 1", len(reps))
+	}
+
+	rep := reps[0]
+	if rep.Server.Name != "test" {
+		t.Errorf("Server.Name = %q, want %q", rep.Server.Name, "test")
+	}
+	if rep.Client.IP != "192.0.2.1" {
+		t.Errorf("Client.IP = %q, want %q", rep.Client.IP, "192.0.2.1")
+	}
+	if rep.Download <= 0 || rep.BytesReceived == 0 {
+		t.Errorf("download = %v Mbps over %d bytes, want a measured rate", rep.Download, rep.BytesReceived)
+	}
+	if rep.Upload <= 0 || rep.BytesSent == 0 {
+		t.Errorf("upload = %v Mbps over %d bytes, want a measured rate", rep.Upload, rep.BytesSent)
+	}
+	if rep.DownloadDetails == nil || len(rep.DownloadDetails.Samples) == 0 {
+		t.Errorf("download details = %+v, want rate samples", rep.DownloadDetails)
+	}
+	if rep.UploadDetails == nil || len(rep.UploadDetails.Samples) == 0 {
+		t.Errorf("upload details = %+v, want rate samples", rep.UploadDetails)
+	}
+	if d := rep.DownloadDetails; d != nil && (d.Requests.Succeeded == 0 || d.Requests.Failed != 0) {
+		t.Errorf("download requests = %+v, want only successes", d.Requests)
+	}
+	// --no-icmp asked for HTTP, so it is no fallback
+	if m := rep.Measurement; m == nil || m.ServerID != 1 || m.PingMethod != "http" || m.PingFallback || m.Family != "IPv4" || m.DownloadStreams != 1 || m.UploadStreams != 1 || m.UploadSize != 64 {
+		t.Errorf("measurement = %+v, want server 1, HTTP ping, IPv4, 1 stream each way, 64 KiB uploads", rep.Measurement)
+	}
+}
+
+func TestRunnerMeasuresLoadedLatency(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	ts := newTestBackend(t)
+
+	opts := DefaultOptions()
+	opts.Servers = []defs.Server{{ID: 1, Server: ts.URL, DownloadURL: "garbage", UploadURL: "empty", PingURL: "empty", GetIPURL: "getIP"}}
+	opts.ServerIDs = []int{1}
+	opts.NoICMP = true
+	opts.NoUpload = true
+	opts.Concurrent = 1
+	opts.Duration = 600 * time.Millisecond
+	opts.LoadedLatency = true
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	reps, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	rep := reps[0]
+	if rep.DownloadDetails == nil || rep.DownloadDetails.LoadedLatency == nil {
+		t.Fatalf("download details = %+v, want latency under load", rep.DownloadDetails)
+	}
+	if l := rep.DownloadDetails.LoadedLatency; l.Loaded <= 0 || l.Grade == "" {
+		t.Errorf("loaded latency = %+v, want a measured ping and a grade", l)
+	}
+	if rep.Bufferbloat != rep.DownloadDetails.LoadedLatency.Grade {
+		t.Errorf("Bufferbloat = %q, want the download's grade %q", rep.Bufferbloat, rep.DownloadDetails.LoadedLatency.Grade)
+	}
+}
+
+// The run's budget spans every server: once the first has spent it, the
+// second is not downloaded from at all.
+func TestRunnerMaxBytesSpansServers(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	ts := newTestBackend(t)
+
+	opts := DefaultOptions()
+	for id := 1; id <= 2; id++ {
+		opts.Servers = append(opts.Servers, defs.Server{ID: id, Server: ts.URL, DownloadURL: "garbage", UploadURL: "empty", PingURL: "empty", GetIPURL: "getIP"})
+	}
+	opts.ServerIDs = []int{-1}
+	opts.NoICMP = true
+	opts.NoUpload = true
+	opts.Concurrent = 1
+	opts.Duration = 5 * time.Second
+	opts.MaxBytes = 2 << 20
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+	reps, err := r.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(reps) != 2 {
+		t.Fatalf("got %d reports, want 2", len(reps))
+	}
+
+	for i, rep := range reps {
+		if !rep.BudgetLimited {
+			t.Errorf("report %d is not marked budget-limited", i)
+		}
+	}
+	if d := reps[0].DownloadDetails; d == nil || d.EndReason != defs.EndBudget {
+		t.Errorf("first download details = %+v, want it ended by the budget", d)
+	}
+	if reps[1].DownloadDetails != nil || reps[1].BytesReceived != 0 {
+		t.Errorf("second server downloaded %d byte(s), want none once the budget is spent", reps[1].BytesReceived)
+	}
+}
+
+func TestRunnerInterruptedKeepsPartialReport(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	ts := newTestBackend(t)
+
+	opts := DefaultOptions()
+	opts.Servers = []defs.Server{{ID: 1, Server: ts.URL, DownloadURL: "garbage", UploadURL: "empty", PingURL: "empty", GetIPURL: "getIP"}}
+	opts.ServerIDs = []int{1}
+	opts.NoICMP = true
+	opts.Concurrent = 1
+	opts.Duration = 10 * time.Second
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Second, cancel)
+	reps, err := r.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want context.Canceled", err)
+	}
+	if len(reps) != 1 {
+		t.Fatalf("got %d reports, want the interrupted one", len(reps))
+	}
+
+	rep := reps[0]
+	if !rep.Interrupted {
+		t.Error("report is not marked as interrupted")
+	}
+	if rep.Ping <= 0 || rep.BytesReceived == 0 {
+		t.Errorf("ping %v ms, %d byte(s) received, want what was measured before the interruption", rep.Ping, rep.BytesReceived)
+	}
+	if rep.UploadDetails != nil {
+		t.Error("upload ran after the interruption")
+	}
+}
+
+// Server selection waits on every server's ping, and an interrupt must not
+// wait with it
+func TestRunnerInterruptedDuringSelection(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	opts := DefaultOptions()
+	opts.Servers = []defs.Server{{ID: 1, Server: ts.URL, DownloadURL: "garbage", UploadURL: "empty", PingURL: "empty", GetIPURL: "getIP"}}
+	opts.NoICMP = true
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run error = %v, want context.Canceled", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Run took %s to stop, want it to stop on the interrupt", d)
+	}
+}
+
+func TestRunnerPhaseBudget(t *testing.T) {
+	cases := []struct {
+		name       string
+		run, phase uint64
+		spent      uint64
+		want       uint64
+		wantRun    bool
+	}{
+		{"no budget", 0, 0, 5, 0, true},
+		{"phase cap only", 0, 100, 500, 100, true},
+		{"run budget left", 1000, 0, 400, 600, true},
+		{"smaller of the two", 1000, 100, 400, 100, true},
+		{"run budget spent", 1000, 100, 1000, 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Runner{opts: Options{MaxBytes: c.run, MaxPhaseBytes: c.phase}}
+			got, ok := r.phaseBudget(c.spent)
+			if got != c.want || ok != c.wantRun {
+				t.Errorf("phaseBudget(%d) = %d, %t, want %d, %t", c.spent, got, ok, c.want, c.wantRun)
+			}
+		})
+	}
+}
+
+// An adaptive phase records the streams it ramped up to, not the cap
+func TestMeasurementRecordsStreamsUsed(t *testing.T) {
+	r := &Runner{opts: Options{Concurrent: 1, Adaptive: true, MaxConcurrent: 8}}
+	m := r.measurement(&defs.Server{ID: 1}, &defs.PingResult{}, &defs.ConnInfo{}, &report.Transfer{Streams: 5}, nil)
+	if m.DownloadStreams != 5 || m.UploadStreams != 0 || m.MaxStreams != 8 {
+		t.Errorf("measurement = %+v, want 5 download streams of up to 8, no upload", m)
+	}
+}
+
+func TestNewRunnerRejectsInvalidOptions(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(*Options)
+	}{
+		{"no streams", func(o *Options) { o.Concurrent = 0 }},
+		{"no duration", func(o *Options) { o.Duration = 0 }},
+		{"convergence window over the duration", func(o *Options) { o.ConvergeWindow = o.Duration }},
+		{"warm-up and convergence window over the duration", func(o *Options) { o.Warmup, o.ConvergeWindow = 10*time.Second, 5*time.Second }},
+		{"warm-up and automatic warm-up", func(o *Options) { o.Warmup, o.AutoWarmup = time.Second, true }},
+		{"error ratio over 1", func(o *Options) { o.MaxErrorRatio = 1.5 }},
+		{"source and interface", func(o *Options) { o.Source, o.Interface = "192.0.2.1", "eth0" }},
+		{"servers and excludes", func(o *Options) { o.ServerIDs, o.ExcludeIDs = []int{1}, []int{2} }},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			c.modify(&opts)
+			if _, err := NewRunner(opts); err == nil {
+				t.Error("NewRunner accepted the options, want an error")
+			}
+		})
+	}
+}
+
+// A runner is configured through its own client; building one must leave the
+// process-wide default alone for anything else sharing the process.
+func TestNewRunnerLeavesDefaultClientAlone(t *testing.T) {
+	before := *http.DefaultClient
+
+	opts := DefaultOptions()
+	opts.Timeout = time.Second
+	opts.SkipCertVerify = true
+	if _, err := NewRunner(opts); err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+
+	if http.DefaultClient.Timeout != before.Timeout || http.DefaultClient.Transport != before.Transport {
+		t.Error("NewRunner modified http.DefaultClient")
+	}
+}
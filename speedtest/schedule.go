@@ -0,0 +1,294 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+const (
+	// failureBackoff is how long after the first of a row of failed runs the
+	// next one waits at least, doubled for each failure after it up to
+	// maxFailureBackoff
+	failureBackoff    = time.Minute
+	maxFailureBackoff = time.Hour
+)
+
+// schedule says when the run after one at a given time is due
+type schedule interface {
+	next(after time.Time) time.Time
+}
+
+// every is a schedule of runs a fixed interval apart
+type every time.Duration
+
+func (e every) next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// cronSchedule is a schedule given as a five-field cron expression: minute,
+// hour, day of month, month and day of week. Each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// like cron, when both day fields are restricted a day matching either
+	// one matches
+	domOrDow bool
+}
+
+// cronField describes the values one field of an expression takes
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is Sunday too
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// cronDescriptors are the shorthands cron accepts for common expressions
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses a cron expression: five fields of values, ranges (a-b),
+// steps (*/n, a-b/n) and lists of those, with month and weekday names, or one
+// of the @ shorthands. Times are in the local time zone.
+func parseCron(expr string) (*cronSchedule, error) {
+	if d, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: want %d fields, got %d", expr, len(cronFields), len(fields))
+	}
+
+	var sets [5]uint64
+	for i, f := range cronFields {
+		set, err := f.parse(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+
+	// fold Sunday as 7 into 0
+	if sets[4]&(1<<7) != 0 {
+		sets[4] = sets[4]&^(1<<7) | 1
+	}
+
+	s := &cronSchedule{
+		minute:   sets[0],
+		hour:     sets[1],
+		dom:      sets[2],
+		month:    sets[3],
+		dow:      sets[4],
+		domOrDow: !strings.HasPrefix(fields[2], "*") && !strings.HasPrefix(fields[4], "*"),
+	}
+	if s.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron expression %q never matches", expr)
+	}
+	return s, nil
+}
+
+// parse turns one field into the set of values it matches
+func (f cronField) parse(field string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s", stepStr, f.name)
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = f.value(loStr); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = f.value(hiStr); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// a/n runs from a to the end of the field
+				hi = f.max
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid range %q in %s", rng, f.name)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// value parses a single value of the field, a number or a name
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return i + f.min, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid %s %q", f.name, s)
+	}
+	return v, nil
+}
+
+// next returns the first matching minute after `after`, or the zero time when
+// none does within five years, as for February 30th
+func (s *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// on the wall clock: truncating the instant would land off the
+			// hour where the zone's offset is not whole hours
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domOrDow {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// scheduleFromContext returns the schedule --interval or --cron gives, or nil
+// when neither does
+func scheduleFromContext(c *cli.Context) (schedule, error) {
+	interval, expr := c.Duration(defs.OptionInterval), c.String(defs.OptionCron)
+	switch {
+	case interval != 0 && expr != "":
+		return nil, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionInterval, defs.OptionCron)
+	case interval < 0:
+		return nil, errors.New("invalid interval")
+	case interval > 0:
+		return every(interval), nil
+	case expr != "":
+		return parseCron(expr)
+	}
+	return nil, nil
+}
+
+// failureDelay is the least a run waits after `failures` failed runs in a row
+func failureDelay(failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	// past 2^6 the cap is reached anyway, and the shift cannot overflow
+	shift := min(failures-1, 6)
+	return min(failureBackoff<<shift, maxFailureBackoff)
+}
+
+// runScheduled runs a test each time sched says, plus up to jitter of random
+// delay, until ctx is cancelled. Runs never overlap: the times a run overruns
+// are skipped. A failed run does not stop the schedule, but holds the next
+// one back for longer each time in a row. write is called with the results of
+// every run that measured something, including the partial results of a run
+// that was interrupted.
+func runScheduled(ctx context.Context, runner *Runner, sched schedule, jitter time.Duration, write func(reps []report.JSONReport, interrupted bool) error) error {
+	failures := 0
+	for {
+		start := time.Now()
+		reps, err := runner.Run(ctx)
+		interrupted := errors.Is(err, context.Canceled)
+		if len(reps) > 0 || interrupted {
+			if werr := write(reps, interrupted); werr != nil {
+				output.WriteError("Cannot write results: %s\n", werr)
+			}
+		}
+		if interrupted {
+			return err
+		}
+
+		if err != nil || len(reps) == 0 {
+			failures++
+			if err == nil {
+				err = ErrNoServer
+			}
+			output.WriteError("Test failed (%d in a row): %s\n", failures, err)
+		} else {
+			failures = 0
+		}
+
+		now := time.Now()
+		due := sched.next(start)
+		if due.Before(now) {
+			output.WriteError("The test ran past the time the next one was due, skipping it\n")
+			due = sched.next(now)
+		}
+		if backoff := now.Add(failureDelay(failures)); due.Before(backoff) {
+			// a cron schedule keeps to its times, and waits for the first
+			// one after the backoff
+			due = backoff
+			if cron, ok := sched.(*cronSchedule); ok {
+				due = cron.next(backoff)
+			}
+		}
+		if due.IsZero() {
+			return errors.New("schedule has no further runs")
+		}
+		if jitter > 0 {
+			due = due.Add(rand.N(jitter))
+		}
+
+		output.WriteUI("Next test at %s\n", due.Format(time.DateTime))
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			// stopping between runs loses nothing
+			return nil
+		}
+	}
+}
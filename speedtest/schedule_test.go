@@ -0,0 +1,187 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+	"github.com/librespeed/speedtest-cli/report"
+)
+
+func TestCronNext(t *testing.T) {
+	// a Wednesday
+	from := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.January, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.January, 31, 10, 15, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.January, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, time.February, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2024, time.January, 31, 13, 0, 0, 0, time.UTC)},
+		{"5,45 10 * * *", time.Date(2024, time.January, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC)},
+		// both day fields restricted: either matches
+		{"0 0 15 * fri", time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatalf("parseCron: %v", err)
+			}
+			if got := s.next(from); !got.Equal(tt.want) {
+				t.Errorf("next = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// Hours are stepped on the wall clock, which in some zones is not a whole
+// number of hours off UTC
+func TestCronNextOffHourZones(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+
+	for _, zone := range []string{"Asia/Kolkata", "Asia/Kathmandu", "America/St_Johns"} {
+		t.Run(zone, func(t *testing.T) {
+			loc, err := time.LoadLocation(zone)
+			if err != nil {
+				t.Fatalf("LoadLocation: %v", err)
+			}
+			time.Local = loc
+			// a Wednesday
+			from := time.Date(2024, time.January, 31, 10, 7, 30, 0, loc)
+
+			tests := []struct {
+				expr string
+				want time.Time
+			}{
+				{"@daily", time.Date(2024, time.February, 1, 0, 0, 0, 0, loc)},
+				{"0 12 * * *", time.Date(2024, time.January, 31, 12, 0, 0, 0, loc)},
+				{"30 9 * * 1", time.Date(2024, time.February, 5, 9, 30, 0, 0, loc)},
+			}
+			for _, tt := range tests {
+				s, err := parseCron(tt.expr)
+				if err != nil {
+					t.Errorf("parseCron(%q): %v", tt.expr, err)
+					continue
+				}
+				if got := s.next(from); !got.Equal(tt.want) {
+					t.Errorf("%q: next = %s, want %s", tt.expr, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCronRejects(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * foo *",
+		"0 0 30 feb *",
+		"@often",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestFailureDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{7, maxFailureBackoff},
+		{1000, maxFailureBackoff},
+	}
+	for _, tt := range tests {
+		if got := failureDelay(tt.failures); got != tt.want {
+			t.Errorf("failureDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRunScheduledReusesServerList(t *testing.T) {
+	output.SetQuiet(true)
+	defer output.SetQuiet(false)
+
+	backend := newTestBackend(t)
+	var fetches atomic.Int32
+	list := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode([]defs.Server{{
+			ID:          1,
+			Name:        "test",
+			Server:      backend.URL,
+			DownloadURL: "garbage",
+			UploadURL:   "empty",
+			PingURL:     "empty",
+			GetIPURL:    "getIP",
+		}})
+	}))
+	defer list.Close()
+
+	opts := DefaultOptions()
+	opts.ServerListURL = list.URL
+	opts.ServerListTTL = time.Hour
+	opts.NoICMP = true
+	opts.NoDownload = true
+	opts.NoUpload = true
+
+	r, err := NewRunner(opts)
+	if err != nil {
+		t.Fatalf("NewRunner: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var runs int
+	err = runScheduled(ctx, r, every(10*time.Millisecond), 0, func(reps []report.JSONReport, interrupted bool) error {
+		if len(reps) != 1 || interrupted {
+			t.Errorf("run %d wrote %d reports, interrupted %t", runs, len(reps), interrupted)
+		}
+		if runs++; runs == 3 {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runScheduled: %v", err)
+	}
+	if runs != 3 {
+		t.Errorf("%d runs, want 3", runs)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("server list fetched %d times, want 1", n)
+	}
+}
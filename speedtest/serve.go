@@ -0,0 +1,121 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/librespeed/speedtest-cli/backend"
+	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/output"
+)
+
+// Serve runs a LibreSpeed backend until interrupted, so another copy of this
+// program, or any LibreSpeed client, can test against it
+func Serve(c *cli.Context) error {
+	cert, key := c.String(defs.OptionTLSCert), c.String(defs.OptionTLSKey)
+	if (cert == "") != (key == "") {
+		return withExitCode(ExitUsage, fmt.Errorf("options '%s' and '%s' must be given together", defs.OptionTLSCert, defs.OptionTLSKey))
+	}
+
+	ln, err := net.Listen("tcp", c.String(defs.OptionListen))
+	if err != nil {
+		return err
+	}
+
+	baseURL := c.String(defs.OptionAdvertiseURL)
+	if baseURL == "" {
+		if baseURL, err = defaultBaseURL(ln.Addr(), cert != ""); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	name := c.String(defs.OptionServerName)
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	if file := c.String(defs.OptionServerList); file != "" {
+		if err := writeServerList(file, backend.Entry(1, name, baseURL)); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	srv := &http.Server{
+		Handler: backend.NewHandler(),
+		// the test endpoints stream for as long as the client keeps asking,
+		// so only the headers get a deadline
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// stop accepting and let the tests in flight finish once interrupted
+	shutdown := make(chan error, 1)
+	go func() {
+		<-c.Context.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- srv.Shutdown(ctx)
+	}()
+
+	output.WriteUI("Serving %s at %s\n", output.Sanitize(name), baseURL)
+	if cert != "" {
+		err = srv.ServeTLS(ln, cert, key)
+	} else {
+		err = srv.Serve(ln)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	// serving stops as soon as the shutdown starts, before it is over
+	if err := <-shutdown; err != nil {
+		return fmt.Errorf("tests still in flight when shutting down: %w", err)
+	}
+	return nil
+}
+
+// defaultBaseURL is the URL a listener is reachable at when none is given:
+// the host name when listening on every address, since a client elsewhere
+// cannot reach 0.0.0.0 or [::]
+func defaultBaseURL(addr net.Addr, secure bool) (string, error) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "", err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			return "", fmt.Errorf("cannot work out the URL to advertise, give --%s: %w", defs.OptionAdvertiseURL, err)
+		}
+	}
+
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/", nil
+}
+
+// writeServerList writes a server list holding only `server`, in the format
+// --local-json reads, to `file`, or to stdout for "-"
+func writeServerList(file string, server defs.Server) error {
+	b, err := json.MarshalIndent([]defs.Server{server}, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if file == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	output.WriteUI("Writing server list to %s\n", file)
+	return os.WriteFile(file, b, 0644)
+}
@@ -0,0 +1,37 @@
+package speedtest
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestDefaultBaseURL(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no host name: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		addr   net.Addr
+		secure bool
+		want   string
+	}{
+		{"bound address is kept", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8989}, false, "http://192.0.2.1:8989/"},
+		{"IPv6 is bracketed", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}, true, "https://[2001:db8::1]:443/"},
+		{"any address becomes the host name", &net.TCPAddr{IP: net.IPv6unspecified, Port: 8989}, false, "http://" + net.JoinHostPort(hostname, "8989") + "/"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := defaultBaseURL(c.addr, c.secure)
+			if err != nil {
+				t.Fatalf("defaultBaseURL: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("defaultBaseURL = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
@@ -1,25 +1,27 @@
 package speedtest
 
 import (
+	"bytes"
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/gocarina/gocsv"
 	"github.com/urfave/cli/v2"
 
 	"github.com/librespeed/speedtest-cli/defs"
+	"github.com/librespeed/speedtest-cli/history"
 	"github.com/librespeed/speedtest-cli/output"
 	"github.com/librespeed/speedtest-cli/report"
 )
@@ -32,10 +34,6 @@ const (
 	defaultTelemetryServer = "https://librespeed.org"
 	defaultTelemetryPath   = "/results/telemetry.php"
 	defaultTelemetryShare  = "/results/"
-
-	forceNothing = 0
-	forceHttps   = 1
-	forceHttp    = 2
 )
 
 type PingJob struct {
@@ -51,21 +49,21 @@ type PingResult struct {
 // SpeedTest is the actual main function that handles the speed test(s)
 func SpeedTest(c *cli.Context) error {
 	// check for suppressed output flags
-	var silent bool
-	if c.Bool(defs.OptionSimple) || c.Bool(defs.OptionJSON) || c.Bool(defs.OptionCSV) || c.Bool(defs.OptionJSONStream) {
+	if c.Bool(defs.OptionSimple) || c.Bool(defs.OptionJSON) || c.Bool(defs.OptionCSV) || c.Bool(defs.OptionInflux) || c.Bool(defs.OptionJSONStream) {
 		output.SetQuiet(true)
-		silent = true
 	}
 
 	// mixing a JSON document into a line stream would leave neither format
 	// parseable on its own, so the stream conflicts with --json and --csv
 	// rather than combining with them
-	if c.Bool(defs.OptionJSONStream) && (c.Bool(defs.OptionJSON) || c.Bool(defs.OptionCSV)) {
+	if c.Bool(defs.OptionJSONStream) && (c.Bool(defs.OptionJSON) || c.Bool(defs.OptionCSV) || c.Bool(defs.OptionInflux)) {
 		other := defs.OptionCSV
 		if c.Bool(defs.OptionJSON) {
 			other = defs.OptionJSON
+		} else if c.Bool(defs.OptionInflux) {
+			other = defs.OptionInflux
 		}
-		return fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionJSONStream, other)
+		return withExitCode(ExitUsage, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionJSONStream, other))
 	}
 	output.SetStream(c.Bool(defs.OptionJSONStream))
 
@@ -97,10 +95,6 @@ func SpeedTest(c *cli.Context) error {
 		return nil
 	}
 
-	if c.String(defs.OptionSource) != "" && c.String(defs.OptionInterface) != "" {
-		return fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionSource, defs.OptionInterface)
-	}
-
 	// set CSV delimiter
 	gocsv.TagSeparator = c.String(defs.OptionCSVDelimiter)
 
@@ -124,17 +118,17 @@ func SpeedTest(c *cli.Context) error {
 			b, err := os.ReadFile(telemetryJSON)
 			if err != nil {
 				output.WriteError("Cannot read %s: %s\n", telemetryJSON, err)
-				return err
+				return withExitCode(ExitUsage, err)
 			}
 			if err := json.Unmarshal(b, &telemetryServer); err != nil {
 				output.WriteError("Error parsing %s: %s\n", telemetryJSON, err)
-				return err
+				return withExitCode(ExitUsage, err)
 			}
 		}
 
 		if telemetryLevel != "" {
 			if telemetryLevel != "disabled" && telemetryLevel != "basic" && telemetryLevel != "full" && telemetryLevel != "debug" {
-				output.Fatalf("Unsupported telemetry level: %s", telemetryLevel)
+				return withExitCode(ExitUsage, fmt.Errorf("unsupported telemetry level: %s", telemetryLevel))
 			}
 			telemetryServer.Level = telemetryLevel
 		} else if telemetryServer.Level == "" {
@@ -160,253 +154,383 @@ func SpeedTest(c *cli.Context) error {
 		}
 	}
 
-	if req := c.Int(defs.OptionConcurrent); req <= 0 {
-		output.WriteError("Concurrent requests cannot be lower than 1: %d is given\n", req)
-		return errors.New("invalid concurrent requests setting")
+	// everything up to the runner checks the command line; failing there
+	// is a usage error, and nothing has been tested yet
+	opts, err := optionsFromContext(c)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
 	}
+	opts.Telemetry = telemetryServer
 
-	noICMP := c.Bool(defs.OptionNoICMP)
-
-	// HTTP requests timeout
-	http.DefaultClient.Timeout = time.Duration(c.Int(defs.OptionTimeout)) * time.Second
+	sched, err := scheduleFromContext(c)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
+	if c.Duration(defs.OptionRandomDelay) < 0 {
+		return withExitCode(ExitUsage, errors.New("invalid random delay"))
+	}
+
+	// a dual-stack run picks the family of each test itself, which a source
+	// address, being of one family, would not allow; and its comparisons
+	// have no place in a schedule's results
+	if c.Bool(defs.OptionDualStack) {
+		var other string
+		switch {
+		case c.Bool(defs.OptionIPv4):
+			other = defs.OptionIPv4
+		case c.Bool(defs.OptionIPv6):
+			other = defs.OptionIPv6
+		case c.String(defs.OptionSource) != "":
+			other = defs.OptionSource
+		case c.Duration(defs.OptionInterval) != 0:
+			other = defs.OptionInterval
+		case c.String(defs.OptionCron) != "":
+			other = defs.OptionCron
+		}
+		if other != "" {
+			return withExitCode(ExitUsage, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionDualStack, other))
+		}
+	}
 
-	forceIPv4 := c.Bool(defs.OptionIPv4)
-	forceIPv6 := c.Bool(defs.OptionIPv6)
+	checks, err := thresholdsFromContext(c)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
+	}
 
-	var network string
-	switch {
-	case forceIPv4:
-		network = "ip4"
-	case forceIPv6:
-		network = "ip6"
-	default:
-		network = "ip"
-	}
-
-	transport := http.DefaultTransport.(*http.Transport).Clone()
-	// tune connection pool for concurrent speed tests
-	concurrent := c.Int(defs.OptionConcurrent)
-	transport.MaxIdleConnsPerHost = concurrent + 2
-	transport.MaxConnsPerHost = concurrent + 2
-
-	if caCertFileName := c.String(defs.OptionCACert); caCertFileName != "" {
-		caCert, err := os.ReadFile(caCertFileName)
+	// check the InfluxDB settings before testing rather than after
+	var influx *influxWriter
+	if influxURL := c.String(defs.OptionInfluxURL); influxURL != "" {
+		influx, err = newInfluxWriter(influxURL, c.String(defs.OptionInfluxOrg), c.String(defs.OptionInfluxBucket), c.String(defs.OptionInfluxToken))
 		if err != nil {
-			output.Fatal(err)
-		}
-		caCertPool := x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
-
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: c.Bool(defs.OptionSkipCertVerify),
-			RootCAs:            caCertPool,
-		}
-	} else {
-		transport.TLSClientConfig = &tls.Config{
-			InsecureSkipVerify: c.Bool(defs.OptionSkipCertVerify),
+			return withExitCode(ExitUsage, err)
 		}
 	}
 
-	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
+	runner, err := NewRunner(opts)
+	if err != nil {
+		return withExitCode(ExitUsage, err)
 	}
-	// bind to source IP address if given
-	if src := c.String(defs.OptionSource); src != "" {
-		var err error
-		dialer, err = newDialerAddressBound(src, network)
+
+	// if --list is given, list all the servers fetched and exit
+	if c.Bool(defs.OptionList) {
+		servers, err := runner.ServerList()
 		if err != nil {
+			output.WriteError("Error when fetching server list: %s\n", err)
 			return err
 		}
+		for _, svr := range servers {
+			var sponsorMsg string
+			if svr.Sponsor() != "" {
+				sponsorMsg = fmt.Sprintf(" [Sponsor: %s]", output.Sanitize(svr.Sponsor()))
+			}
+			// --list goes to stdout, so a newline smuggled into a server name
+			// would forge an entry for anything parsing it
+			output.WriteOut("%d: %s (%s) %s\n", svr.ID, output.Sanitize(svr.Name), output.Sanitize(svr.Server), sponsorMsg)
+		}
+		return nil
 	}
 
-	// bind to interface if given
-	iface := c.String(defs.OptionInterface)
-	fwmark := c.Int(defs.OptionFwmark)
-
-	if iface != "" || fwmark > 0 {
-		var err error
-		dialer, err = newDialerInterfaceOrFwmarkBound(iface, fwmark)
-		if err != nil {
+	var store *history.Store
+	if c.Bool(defs.OptionSaveHistory) {
+		if store, err = openHistory(c); err != nil {
 			return err
 		}
-		// ICMP ping does not support interface binding.
-		noICMP = true
+		defer store.Close()
 	}
 
-	// enforce if ipv4/ipv6 is forced
-	var dialContext func(context.Context, string, string) (net.Conn, error)
-	switch {
-	case forceIPv4:
-		dialContext = func(ctx context.Context, network, address string) (conn net.Conn, err error) {
-			return dialer.DialContext(ctx, "tcp4", address)
+	// save keeps a run's results wherever the flags ask for, beside the
+	// report printed
+	save := func(reps []report.JSONReport) error {
+		if store != nil && len(reps) > 0 {
+			if err := store.Add(reps); err != nil {
+				return fmt.Errorf("cannot save the results to the history: %w", err)
+			}
 		}
-	case forceIPv6:
-		dialContext = func(ctx context.Context, network, address string) (conn net.Conn, err error) {
-			return dialer.DialContext(ctx, "tcp6", address)
+		if file := c.String(defs.OptionPrometheusFile); file != "" {
+			if err := writePrometheusFile(file, reps); err != nil {
+				return fmt.Errorf("cannot write %s: %w", file, err)
+			}
+		}
+		if influx != nil {
+			// an interrupted run has already cancelled c.Context, and its
+			// partial results are still worth keeping
+			if err := influx.write(context.WithoutCancel(c.Context), reps); err != nil {
+				return fmt.Errorf("cannot write to InfluxDB: %w", err)
+			}
 		}
-	default:
-		dialContext = dialer.DialContext
+		return nil
+	}
+	// write sends a run's results to every output the flags ask for
+	write := func(reps []report.JSONReport, interrupted bool) error {
+		writeReports(c, reps, interrupted)
+		return save(reps)
+	}
+
+	if c.Bool(defs.OptionDualStack) {
+		return runDualStack(c, runner, checks, save)
+	}
+
+	if sched != nil {
+		// a scheduled run keeps going after a failed check; the check only
+		// tells on that run
+		writeAndCheck := func(reps []report.JSONReport, interrupted bool) error {
+			err := write(reps, interrupted)
+			if !interrupted {
+				checks.checkReports(reps)
+			}
+			return err
+		}
+		err := runScheduled(c.Context, runner, sched, c.Duration(defs.OptionRandomDelay), writeAndCheck)
+		if errors.Is(err, context.Canceled) {
+			return cli.Exit("Interrupted, the results measured so far were written", ExitInterrupted)
+		}
+		return err
+	}
+
+	reps, err := runner.Run(c.Context)
+	interrupted := errors.Is(err, context.Canceled)
+	if errors.Is(err, ErrNoServer) {
+		return withExitCode(ExitNoServer, err)
+	}
+	if err != nil && !interrupted {
+		return err
 	}
 
-	// set default HTTP client's Transport to the one that binds the source address
-	// this is modified from http.DefaultTransport
-	transport.DialContext = dialContext
-	http.DefaultClient.Transport = transport
+	if err := write(reps, interrupted); err != nil {
+		return err
+	}
+	if interrupted {
+		return cli.Exit("Interrupted, the results measured so far were written", ExitInterrupted)
+	}
+	if len(reps) == 0 {
+		return withExitCode(ExitNoServer, ErrNoServer)
+	}
+	if failed := checks.checkReports(reps); failed > 0 {
+		return withExitCode(ExitThreshold, fmt.Errorf("%d check(s) failed", failed))
+	}
+	return nil
+}
+
+// optionsFromContext translates the command line flags into runner options,
+// reading the local server list if one is given. Telemetry settings are left
+// to the caller.
+func optionsFromContext(c *cli.Context) (Options, error) {
+	opts := DefaultOptions()
+
+	if str := c.String(defs.OptionServerJSON); str != "" {
+		opts.ServerListURL = str
+	}
+	opts.ServerIDs = c.IntSlice(defs.OptionServer)
+	opts.ExcludeIDs = c.IntSlice(defs.OptionExclude)
+	opts.ServerListTTL = c.Duration(defs.OptionServerListTTL)
 
 	// no scheme is forced by default
 	// force https if --secure is given
 	// else force http if --insecure is given
-	var forceScheme int = forceNothing
 	if c.Bool(defs.OptionSecure) {
-		forceScheme = forceHttps
+		opts.ForceScheme = SchemeHTTPS
 	} else if c.Bool(defs.OptionInsecure) {
-		forceScheme = forceHttp
+		opts.ForceScheme = SchemeHTTP
+	}
+
+	opts.ForceIPv4 = c.Bool(defs.OptionIPv4)
+	opts.ForceIPv6 = c.Bool(defs.OptionIPv6)
+	opts.Source = c.String(defs.OptionSource)
+	opts.Interface = c.String(defs.OptionInterface)
+	opts.Fwmark = c.Int(defs.OptionFwmark)
+	opts.Timeout = time.Duration(c.Int(defs.OptionTimeout)) * time.Second
+	opts.CACert = c.String(defs.OptionCACert)
+	opts.SkipCertVerify = c.Bool(defs.OptionSkipCertVerify)
+
+	opts.NoICMP = c.Bool(defs.OptionNoICMP)
+	opts.PingCount = c.Int(defs.OptionPingCount)
+	opts.PingSamples = c.Bool(defs.OptionPingSamples)
+	opts.NoDownload = c.Bool(defs.OptionNoDownload)
+	opts.NoUpload = c.Bool(defs.OptionNoUpload)
+	opts.Concurrent = c.Int(defs.OptionConcurrent)
+	opts.Adaptive = c.Bool(defs.OptionAdaptive)
+	opts.MaxConcurrent = c.Int(defs.OptionMaxConcurrent)
+	opts.Duration = time.Duration(c.Int(defs.OptionDuration)) * time.Second
+	opts.Warmup = time.Duration(c.Float64(defs.OptionWarmup) * float64(time.Second))
+	opts.AutoWarmup = c.Bool(defs.OptionAutoWarmup)
+	opts.LoadedLatency = c.Bool(defs.OptionLoadedLatency)
+	opts.LossCount = c.Int(defs.OptionLossCount)
+	opts.LossInterval = c.Duration(defs.OptionLossInterval)
+	opts.ConvergeWindow = time.Duration(c.Float64(defs.OptionConverge) * float64(time.Second))
+	opts.ConvergeTolerance = c.Float64(defs.OptionConvergeTolerance) / 100
+	opts.MaxErrorRatio = c.Float64(defs.OptionMaxErrorRatio) / 100
+	opts.Chunks = c.Int(defs.OptionChunks)
+	opts.UploadSize = c.Int(defs.OptionUploadSize)
+	opts.NoPreAllocate = c.Bool(defs.OptionNoPreAllocate)
+
+	// NewRunner rejects these too, but names the options rather than the flags
+	switch {
+	case len(opts.ServerIDs) > 0 && len(opts.ExcludeIDs) > 0:
+		return opts, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionServer, defs.OptionExclude)
+	case opts.Source != "" && opts.Interface != "":
+		return opts, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionSource, defs.OptionInterface)
+	case opts.Warmup > 0 && opts.AutoWarmup:
+		return opts, fmt.Errorf("incompatible options '%s' and '%s'", defs.OptionWarmup, defs.OptionAutoWarmup)
 	}
 
-	// load server list
-	var servers []defs.Server
 	var err error
+	if opts.MaxBytes, err = parseSize(c.String(defs.OptionMaxBytes)); err != nil {
+		return opts, fmt.Errorf("invalid --%s: %w", defs.OptionMaxBytes, err)
+	}
+	if opts.MaxPhaseBytes, err = parseSize(c.String(defs.OptionMaxPhaseBytes)); err != nil {
+		return opts, fmt.Errorf("invalid --%s: %w", defs.OptionMaxPhaseBytes, err)
+	}
+
+	opts.DistanceUnit = c.String(defs.OptionDistance)
+	opts.Bytes = c.Bool(defs.OptionBytes)
+	opts.MebiBytes = c.Bool(defs.OptionMebiBytes)
+	opts.TelemetryExtra = c.String(defs.OptionTelemetryExtra)
+
+	// load server list
 	if str := c.String(defs.OptionLocalJSON); str != "" {
 		switch str {
 		case "-":
 			// load server list from stdin
 			output.WriteUI("Using local JSON server list from stdin\n")
-			servers, err = getLocalServersReader(forceScheme, os.Stdin, c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
+			opts.Servers, err = getLocalServersReader(os.Stdin)
 		default:
 			// load server list from local JSON file
 			output.WriteUI("Using local JSON server list: %s\n", str)
-			servers, err = getLocalServers(forceScheme, str, c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
-		}
-	} else {
-		// fetch the server list JSON and parse it into the `servers` array
-		serverUrl := serverListUrl
-		if str := c.String(defs.OptionServerJSON); str != "" {
-			serverUrl = str
+			opts.Servers, err = getLocalServers(str)
 		}
-		output.WriteUI("Retrieving server list from %s\n", serverUrl)
-
-		servers, err = getServerList(forceScheme, serverUrl, c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
-
 		if err != nil {
-			output.WriteUI("Retry with /.well-known/librespeed\n")
-			servers, err = getServerList(forceScheme, wellKnownServerURL(serverUrl), c.IntSlice(defs.OptionExclude), c.IntSlice(defs.OptionServer), !c.Bool(defs.OptionList))
+			output.WriteError("Error when fetching server list: %s\n", err)
+			return opts, err
 		}
 	}
-	if err != nil {
-		output.WriteError("Error when fetching server list: %s\n", err)
-		return err
+
+	return opts, nil
+}
+
+// sizeUnits are the suffixes parseSize accepts, longest first so "MiB" is
+// not taken for "B"
+var sizeUnits = []struct {
+	suffix string
+	bytes  uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a byte count such as "500M", "1.5GB" or "2GiB". Plain and
+// SI suffixes are powers of 1000, like the rates this program reports, and
+// the -iB ones powers of 1024. An empty string is zero.
+func parseSize(str string) (uint64, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, nil
 	}
 
-	// if --list is given, list all the servers fetched and exit
-	if c.Bool(defs.OptionList) {
-		for _, svr := range servers {
-			var sponsorMsg string
-			if svr.Sponsor() != "" {
-				sponsorMsg = fmt.Sprintf(" [Sponsor: %s]", output.Sanitize(svr.Sponsor()))
-			}
-			// --list goes to stdout, so a newline smuggled into a server name
-			// would forge an entry for anything parsing it
-			output.WriteOut("%d: %s (%s) %s\n", svr.ID, output.Sanitize(svr.Name), output.Sanitize(svr.Server), sponsorMsg)
+	mult := uint64(1)
+	for _, u := range sizeUnits {
+		if len(str) > len(u.suffix) && strings.EqualFold(str[len(str)-len(u.suffix):], u.suffix) {
+			str, mult = strings.TrimSpace(str[:len(str)-len(u.suffix)]), u.bytes
+			break
 		}
-		return nil
 	}
 
-	// if --server is given, do speed tests with all of them
-	if len(c.IntSlice(defs.OptionServer)) > 0 {
-		return doSpeedTest(c, servers, telemetryServer, network, silent, noICMP)
-	} else {
-		// else select the fastest server from the list
-		output.WriteUI("Selecting the fastest server based on ping\n")
-
-		var wg sync.WaitGroup
-		jobs := make(chan PingJob, len(servers))
-		results := make(chan PingResult, len(servers))
-		done := make(chan struct{})
-
-		pingList := make(map[int]float64)
+	val, err := strconv.ParseFloat(str, 64)
+	if err != nil || !(val >= 0) || math.IsInf(val, 0) {
+		return 0, fmt.Errorf("not a size: %q", str)
+	}
+	return uint64(val * float64(mult)), nil
+}
 
-		// spawn 10 concurrent pingers
-		for i := 0; i < 10; i++ {
-			go pingWorker(jobs, results, &wg, c.String(defs.OptionSource), network, noICMP)
+// writeReports prints the reports in the format the flags ask for.
+// interrupted marks the stream's result event for a run that was cut short.
+func writeReports(c *cli.Context, reps []report.JSONReport, interrupted bool) {
+	// print result if --simple is given
+	if c.Bool(defs.OptionSimple) {
+		for _, rep := range reps {
+			if c.Bool(defs.OptionBytes) {
+				useMebi := c.Bool(defs.OptionMebiBytes)
+				output.WriteOut("Ping:\t%.2f ms\tJitter:\t%.2f ms\nDownload rate:\t%s\nUpload rate:\t%s\n", rep.Ping, rep.Jitter, humanizeMbps(rep.Download, useMebi), humanizeMbps(rep.Upload, useMebi))
+			} else {
+				output.WriteOut("Ping:\t%.2f ms\tJitter:\t%.2f ms\nDownload rate:\t%.2f Mbps\nUpload rate:\t%.2f Mbps\n", rep.Ping, rep.Jitter, rep.Download, rep.Upload)
+			}
+			if rep.Loss != nil {
+				output.WriteOut("Packet loss:\t%.2f %%\n", rep.Loss.Percent)
+			}
+			// only print to stdout when no machine-readable format is used
+			if rep.Share != "" && !c.Bool(defs.OptionJSON) && !c.Bool(defs.OptionCSV) && !c.Bool(defs.OptionInflux) {
+				output.WriteOut("Share your result: %s\n", rep.Share)
+			}
 		}
+	}
 
-		// send ping jobs to workers
-		for idx, server := range servers {
-			wg.Add(1)
-			jobs <- PingJob{Index: idx, Server: server}
+	// check for --csv or --json. the program prioritize the --csv before the --json. this is the same behavior as speedtest-cli
+	if c.Bool(defs.OptionCSV) {
+		writeCSV(reps)
+	} else if c.Bool(defs.OptionJSON) {
+		// an empty run still prints a JSON array, not null
+		if reps == nil {
+			reps = []report.JSONReport{}
 		}
-		close(jobs)
-
-		go func() {
-			wg.Wait()
-			close(done)
-		}()
-
-	Loop:
-		for {
-			select {
-			case result := <-results:
-				pingList[result.Index] = result.Ping
-			case <-done:
-				break Loop
-			}
+		if b, err := json.Marshal(&reps); err != nil {
+			output.WriteError("Error generating JSON report: %s\n", err)
+		} else {
+			os.Stdout.Write(b[:])
+			os.Stdout.WriteString("\n")
 		}
-
-		if len(pingList) == 0 {
-			output.Fatal("No server is currently available, please try again later.")
+	} else if c.Bool(defs.OptionInflux) {
+		if err := report.WriteInflux(os.Stdout, reps); err != nil {
+			output.WriteError("Error generating InfluxDB line protocol: %s\n", err)
 		}
+	} else if c.Bool(defs.OptionJSONStream) {
+		// the stream's final result event carries the same reports --json
+		// prints, so one parser handles both formats
+		output.WriteEvent(output.ResultEvent{Event: "result", Reports: reps, Interrupted: interrupted})
+	}
+}
 
-		// get the fastest server's index in the `servers` array
-		var serverIdx int
-		for idx, newPing := range pingList {
-			oldPing, ok := pingList[serverIdx]
-
-			if ok {
-				if newPing > 0 && newPing <= oldPing {
-					serverIdx = idx
-				}
-			} else {
-				serverIdx = idx
-			}
-		}
+// writeCSV prints the reports as CSV rows, without the header
+func writeCSV(reps []report.JSONReport) {
+	reps_csv := make([]report.CSVReport, 0, len(reps))
+	for _, rep := range reps {
+		reps_csv = append(reps_csv, report.NewCSVReport(rep))
+	}
 
-		// do speed test on the server
-		return doSpeedTest(c, []defs.Server{servers[serverIdx]}, telemetryServer, network, silent, noICMP)
+	var buf bytes.Buffer
+	if err := gocsv.MarshalWithoutHeaders(&reps_csv, &buf); err != nil {
+		output.WriteError("Error generating CSV report: %s\n", err)
+	} else {
+		os.Stdout.WriteString(strings.TrimRight(buf.String(), "\n\r") + "\n")
 	}
 }
 
-func pingWorker(jobs <-chan PingJob, results chan<- PingResult, wg *sync.WaitGroup, srcIp, network string, noICMP bool) {
-	for job := range jobs {
-		server := job.Server
-		// get the URL of the speed test server from the JSON
-		u, err := server.GetURL()
-		if err != nil {
-			output.WriteDebug("Server URL is invalid for %s (%s), skipping\n", output.Sanitize(server.Name), output.Sanitize(server.Server))
-			wg.Done()
-			continue
-		}
-
-		// check the server is up by accessing the ping URL and checking its returned value == empty and status code == 200
-		if server.IsUp() {
-			// skip ICMP if option given
-			server.NoICMP = noICMP
+// writePrometheusFile writes the reports in Prometheus exposition format to
+// file. It writes a temporary file next to it and renames that over it, so a
+// collector reading the file concurrently sees either the old results or the
+// new ones, never a mix.
+func writePrometheusFile(file string, reps []report.JSONReport) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// a no-op once the rename has happened
+	defer os.Remove(tmp.Name())
 
-			// if server is up, get ping
-			ping, _, err := server.ICMPPingAndJitter(1, srcIp, network)
-			if err != nil {
-				output.WriteDebug("Can't ping server %s (%s), skipping\n", output.Sanitize(server.Name), output.Sanitize(u.Hostname()))
-				wg.Done()
-				continue
-			}
-			// return result
-			results <- PingResult{Index: job.Index, Ping: ping}
-			wg.Done()
-		} else {
-			output.WriteDebug("Server %s (%s) doesn't seem to be up, skipping\n", output.Sanitize(server.Name), output.Sanitize(u.Hostname()))
-			wg.Done()
-		}
+	if err := report.WritePrometheus(tmp, reps); err != nil {
+		tmp.Close()
+		return err
+	}
+	// CreateTemp makes the file readable by its owner only, and the
+	// collector usually runs as another user
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
 	}
+	return os.Rename(tmp.Name(), file)
 }
 
 // wellKnownServerURL builds the /.well-known/librespeed URL from a server
@@ -423,21 +547,16 @@ func wellKnownServerURL(serverURL string) string {
 }
 
 // getServerList fetches the server JSON from a remote server
-func getServerList(forceScheme int, serverList string, excludes, specific []int, filter bool) ([]defs.Server, error) {
-	// --exclude and --server cannot be used at the same time
-	if len(excludes) > 0 && len(specific) > 0 {
-		return nil, errors.New("either --exclude or --server can be used")
-	}
-
+func getServerList(ctx context.Context, client *http.Client, serverList string) ([]defs.Server, error) {
 	// getting the server list from remote
 	var servers []defs.Server
-	req, err := http.NewRequest(http.MethodGet, serverList, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverList, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", defs.UserAgent)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -452,11 +571,11 @@ func getServerList(forceScheme int, serverList string, excludes, specific []int,
 		return nil, err
 	}
 
-	return preprocessServers(servers, forceScheme, excludes, specific, filter)
+	return servers, nil
 }
 
 // getLocalServersReader loads the server JSON from an io.Reader
-func getLocalServersReader(forceScheme int, reader io.ReadCloser, excludes, specific []int, filter bool) ([]defs.Server, error) {
+func getLocalServersReader(reader io.ReadCloser) ([]defs.Server, error) {
 	defer reader.Close()
 
 	var servers []defs.Server
@@ -470,20 +589,20 @@ func getLocalServersReader(forceScheme int, reader io.ReadCloser, excludes, spec
 		return nil, err
 	}
 
-	return preprocessServers(servers, forceScheme, excludes, specific, filter)
+	return servers, nil
 }
 
 // getLocalServers loads the server JSON from a local file
-func getLocalServers(forceScheme int, jsonFile string, excludes, specific []int, filter bool) ([]defs.Server, error) {
+func getLocalServers(jsonFile string) ([]defs.Server, error) {
 	f, err := os.OpenFile(jsonFile, os.O_RDONLY, 0644)
 	if err != nil {
 		return nil, err
 	}
-	return getLocalServersReader(forceScheme, f, excludes, specific, filter)
+	return getLocalServersReader(f)
 }
 
 // preprocessServers makes some needed modifications to the servers fetched
-func preprocessServers(servers []defs.Server, forceScheme int, excludes, specific []int, filter bool) ([]defs.Server, error) {
+func preprocessServers(servers []defs.Server, forceScheme Scheme, excludes, specific []int, filter bool) ([]defs.Server, error) {
 	for i := range servers {
 		u, err := servers[i].GetURL()
 		if err != nil {
@@ -493,9 +612,9 @@ func preprocessServers(servers []defs.Server, forceScheme int, excludes, specifi
 		// if no scheme is defined, use http as default, or https when --secure is given in cli options,
 		// of http if --insecure is given in cli options
 		// if the scheme is predefined and neither --secure nor --insecure is not given, we will use it as-is
-		if forceScheme == forceHttps {
+		if forceScheme == SchemeHTTPS {
 			u.Scheme = "https"
-		} else if forceScheme == forceHttp {
+		} else if forceScheme == SchemeHTTP {
 			u.Scheme = "http"
 		} else if u.Scheme == "" {
 			// if `secure` is not used and no scheme is defined, use http
@@ -1,6 +1,14 @@
 package speedtest
 
-import "testing"
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/librespeed/speedtest-cli/report"
+)
 
 func TestWellKnownServerURL(t *testing.T) {
 	cases := []struct {
@@ -48,3 +56,67 @@ func TestWellKnownServerURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1048576", 1 << 20, false},
+		{"500M", 500e6, false},
+		{"1.5GB", 1.5e9, false},
+		{"2GiB", 2 << 30, false},
+		{"64 kib", 64 << 10, false},
+		{"100B", 100, false},
+		{"M", 0, true},
+		{"-1G", 0, true},
+		{"lots", 0, true},
+		{"NaN", 0, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			got, err := parseSize(c.in)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("parseSize(%q) error = %v, want error %t", c.in, err, c.wantErr)
+			}
+			if got != c.want {
+				t.Errorf("parseSize(%q) = %d, want %d", c.in, got, c.want)
+			}
+		})
+	}
+}
+
+func TestWritePrometheusFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "librespeed.prom")
+	if err := os.WriteFile(file, []byte("stale\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reps := []report.JSONReport{{Server: report.Server{Name: "a"}}, {Server: report.Server{Name: "b"}}}
+	if err := writePrometheusFile(file, reps); err != nil {
+		t.Fatalf("writePrometheusFile: %v", err)
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`librespeed_download_mbps{server="a"`, `librespeed_download_mbps{server="b"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("file is missing %q:\n%s", want, b)
+		}
+	}
+
+	// only the file itself is left behind, readable by the collector
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want only the metrics file", len(entries))
+	}
+	if info, err := os.Stat(file); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0644 {
+		t.Errorf("file mode = %v, want 0644", info.Mode().Perm())
+	}
+}